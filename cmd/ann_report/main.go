@@ -0,0 +1,206 @@
+// Command ann_report compares the recall and latency of an approximate nearest
+// neighbor index of the metadata embeddings, or of the category embeddings,
+// with those of the exact (flat) index.
+//
+// The query vectors are metadata embedding vectors sampled from the database.
+// Recall is the fraction of the k nearest neighbors found by the flat index
+// that are found by the approximate index.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	description = flag.String("index", "IVF1024,Flat", "Faiss index factory description of the approximate index")
+	params      = flag.String("params", "", "Search parameters of the approximate index, e.g. nprobe=16 or efSearch=64")
+	k           = flag.Int("k", 10, "Number of nearest neighbors")
+	numQueries  = flag.Int("queries", 1000, "Number of query vectors")
+	categories  = flag.Bool("categories", false, "Compare category embedding indexes instead of metadata embedding indexes")
+)
+
+// searcher is a metadata or category embedding index.
+type searcher interface {
+	Query(vec []float32, k int64) ([]string, []float32, error)
+	Delete()
+}
+
+// stats are the results of querying an index.
+type stats struct {
+	build     time.Duration
+	latencies []time.Duration
+	results   [][]string
+}
+
+func (s *stats) percentile(p float64) time.Duration {
+	return s.latencies[int(p*float64(len(s.latencies)-1))]
+}
+
+func (s *stats) mean() time.Duration {
+	var total time.Duration
+	for _, l := range s.latencies {
+		total += l
+	}
+	return total / time.Duration(len(s.latencies))
+}
+
+func queryVectors(db *database.DB, n int) ([][]float32, error) {
+	rows, err := db.Query(`
+	SELECT emb FROM metadata_vectors ORDER BY RANDOM() LIMIT ?`, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vecs [][]float32
+	for rows.Next() {
+		var emb []byte
+		if err := rows.Scan(&emb); err != nil {
+			return nil, err
+		}
+		vec, err := vec32.FromBytes(emb)
+		if err != nil {
+			return nil, err
+		}
+		vecs = append(vecs, vec)
+	}
+	return vecs, rows.Err()
+}
+
+// run builds an index and queries it with the query vectors.
+func run(build func() (searcher, error), queries [][]float32) (*stats, error) {
+	start := time.Now()
+	idx, err := build()
+	if err != nil {
+		return nil, err
+	}
+	defer idx.Delete()
+	s := &stats{build: time.Since(start)}
+
+	for _, q := range queries {
+		start := time.Now()
+		ids, _, err := idx.Query(q, int64(*k))
+		if err != nil {
+			return nil, err
+		}
+		s.latencies = append(s.latencies, time.Since(start))
+		s.results = append(s.results, ids)
+	}
+	sort.Slice(s.latencies, func(i, j int) bool {
+		return s.latencies[i] < s.latencies[j]
+	})
+	return s, nil
+}
+
+// recall returns the mean fraction of the exact results found by the
+// approximate index.
+func recall(exact, approx [][]string) float64 {
+	var sum float64
+	var n int
+	for i, want := range exact {
+		if len(want) == 0 {
+			continue
+		}
+		found := make(map[string]bool)
+		for _, id := range approx[i] {
+			found[id] = true
+		}
+		var hits int
+		for _, id := range want {
+			if found[id] {
+				hits++
+			}
+		}
+		sum += float64(hits) / float64(len(want))
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+func main() {
+	flag.Parse()
+
+	searchParams, err := index.ParseSearchParams(*params)
+	if err != nil {
+		log.Fatal(err)
+	}
+	approxConf := &index.FaissConfig{Description: *description, SearchParams: searchParams}
+
+	db, err := database.New(config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	dim, err := db.MetadataVectorDim()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var emb wordemb.Model
+	if *categories {
+		if emb, err = wordemb.Open(db.DB); err != nil {
+			log.Fatal(err)
+		}
+		defer emb.Close()
+	}
+	build := func(cfg *index.FaissConfig) func() (searcher, error) {
+		if *categories {
+			return func() (searcher, error) {
+				return index.BuildCategoryEmbeddingIndex(db, emb, cfg)
+			}
+		}
+		return func() (searcher, error) {
+			return index.BuildMetadataEmbeddingIndex(db, dim, cfg)
+		}
+	}
+
+	queries, err := queryVectors(db, *numQueries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(queries) == 0 {
+		log.Fatal("no metadata vectors")
+	}
+	exact, err := run(build(nil), queries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	approx, err := run(build(approxConf), queries)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%d queries, k = %d\n\n", len(queries), *k)
+	fmt.Printf("%-30s %12s %12s %12s %12s %8s\n",
+		"index", "build", "mean", "p50", "p99", "recall")
+	for _, r := range []struct {
+		name   string
+		stats  *stats
+		recall float64
+	}{
+		{"Flat", exact, 1},
+		{approxConf.String(), approx, recall(exact.results, approx.results)},
+	} {
+		fmt.Printf("%-30s %12v %12v %12v %12v %8.4f\n",
+			r.name,
+			r.stats.build.Round(time.Millisecond),
+			r.stats.mean(),
+			r.stats.percentile(0.5),
+			r.stats.percentile(0.99),
+			r.recall)
+	}
+}
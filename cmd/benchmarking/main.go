@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"strconv"
@@ -11,7 +12,7 @@ import (
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/navigation"
-	"github.com/ekzhu/go-fasttext"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -60,10 +61,13 @@ func main() {
 	}
 	defer db.Close()
 
-	ft := fasttext.NewFastText(config.FasttextPath())
-	defer ft.Close()
+	emb, err := wordemb.Open(db.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer emb.Close()
 
-	metadataIndex, err := index.BuildMetadataEmbeddingIndex(db)
+	metadataIndex, err := index.BuildMetadataEmbeddingIndex(db, emb.Dim(), nil)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -91,7 +95,7 @@ func main() {
 	}
 
 	start := time.Now()
-	organization, _ := navigation.BuildOrganization(db, ft, orgConf, orgIds)
+	organization, _ := navigation.BuildOrganization(context.Background(), db, emb, orgConf, orgIds, nil)
 	_ = organization
 	t := time.Now()
 	fmt.Printf("Time:%0.9f\n", t.Sub(start).Seconds())
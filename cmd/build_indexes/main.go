@@ -0,0 +1,83 @@
+// Command build_indexes writes the data of the metadata embedding, metadata
+// field embedding, joinability and column pair indexes to files in the index
+// directory, from which the server loads the indexes on startup instead of
+// querying the database.
+// The files are only used while the tables they were built from are unchanged.
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// writeMetadataEmbeddingIndex writes the metadata embedding index file with
+// the dimension of the stored metadata vectors.
+func writeMetadataEmbeddingIndex(db *database.DB, path string) error {
+	dim, err := db.MetadataVectorDim()
+	if err != nil {
+		return err
+	}
+	return index.WriteMetadataEmbeddingIndex(db, path, dim)
+}
+
+// writeMetadataFieldIndex returns a function that writes the index file of a
+// metadata field with the dimension of the stored metadata vectors.
+func writeMetadataFieldIndex(field string) func(db *database.DB, path string) error {
+	return func(db *database.DB, path string) error {
+		dim, err := db.MetadataVectorDim()
+		if err != nil {
+			return err
+		}
+		return index.WriteMetadataFieldIndex(db, field, path, dim)
+	}
+}
+
+func main() {
+	db, err := database.New(config.DatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	dir := config.IndexDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	indexes := []struct {
+		table string
+		file  string
+		write func(db *database.DB, path string) error
+	}{
+		{"metadata_vectors", index.MetadataIndexFile, writeMetadataEmbeddingIndex},
+		{"column_sketches", index.JoinabilityIndexFile, index.WriteJoinabilityIndex},
+		{"column_pair_sketches", index.ColumnPairIndexFile, index.WriteColumnPairIndex},
+	}
+	for _, field := range database.MetadataFields {
+		indexes = append(indexes, struct {
+			table string
+			file  string
+			write func(db *database.DB, path string) error
+		}{"metadata_field_vectors", index.MetadataFieldIndexFile(field), writeMetadataFieldIndex(field)})
+	}
+	for _, idx := range indexes {
+		ok, err := db.HasTable(idx.table)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			log.Printf("skipped %s: no %s table", idx.file, idx.table)
+			continue
+		}
+		path := filepath.Join(dir, idx.file)
+		if err := idx.write(db, path); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("wrote", path)
+	}
+}
@@ -32,7 +32,11 @@ func main() {
 	}
 	defer db.Close()
 
-	idx, err := index.BuildMetadataEmbeddingIndex(db)
+	dim, err := db.MetadataVectorDim()
+	if err != nil {
+		panic(err)
+	}
+	idx, err := index.BuildMetadataEmbeddingIndex(db, dim, nil)
 	if err != nil {
 		panic(err)
 	}
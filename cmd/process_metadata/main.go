@@ -1,26 +1,35 @@
 // Command process_metadata creates metadata embedding vectors and stores the
 // metadata and the vectors in the Open Data Link database.
+//
+// With -weighting, the word statistics of the metadata are computed first and
+// the word vectors are weighted by them. The statistics are stored so that
+// queries are embedded with the same weights.
+//
+// Each metadata field is embedded separately, and the metadata vector is the
+// combination of the field vectors weighted by -fieldweights. With -combine,
+// the metadata vectors are only combined again from the stored field vectors.
 package main
 
 import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
-	"github.com/ekzhu/go-fasttext"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-const datasetsDir = "datasets"
-
 type metadata struct {
 	Resource *struct {
 		Name         string
@@ -66,27 +75,198 @@ func removeDuplicates(s []string) []string {
 	return s[:i]
 }
 
-func metadataVector(ft *fasttext.FastText, m *metadata) ([]float32, error) {
-	return wordemb.Vector(ft, []string{
-		m.Resource.Name,
-		m.Resource.Description,
-		m.Resource.Attribution,
-		strings.Join(m.Classification.Categories, " "),
-		strings.Join(m.Classification.Tags, " "),
-		m.Classification.DomainCategory,
-		strings.Join(m.Classification.DomainTags, " "),
-	})
+var (
+	weighting    = flag.String("weighting", "none", "Weighting of the words of the metadata: none, tfidf or sif")
+	fieldWeights = flag.String("fieldweights", "name=2,description=1,attribution=0.5,categories=1,tags=1", "Weights of the field vectors in the metadata vectors; fields that are not listed are ignored")
+	combine      = flag.Bool("combine", false, "Only combine the stored field vectors into metadata vectors with -fieldweights")
+)
+
+// field returns the text of a field of database.MetadataFields.
+func (m *metadata) field(name string) string {
+	switch name {
+	case "name":
+		return m.Resource.Name
+	case "description":
+		return m.Resource.Description
+	case "attribution":
+		return m.Resource.Attribution
+	case "categories":
+		return strings.Join(m.categories(), " ")
+	case "tags":
+		return strings.Join(m.tags(), " ")
+	}
+	panic("unknown metadata field " + name)
+}
+
+// parseFieldWeights parses field weights formatted as comma-separated
+// field=weight pairs.
+func parseFieldWeights(s string) (map[string]float32, error) {
+	weights := make(map[string]float32)
+	for _, p := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid field weight %q", p)
+		}
+		valid := false
+		for _, f := range database.MetadataFields {
+			valid = valid || f == kv[0]
+		}
+		w, err := strconv.ParseFloat(kv[1], 32)
+		if !valid || err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid field weight %q", p)
+		}
+		weights[kv[0]] = float32(w)
+	}
+	return weights, nil
+}
+
+// combineFields returns the normalized weighted sum of the field vectors, nil
+// if none of the weighted fields has a vector.
+func combineFields(vecs map[string][]float32, weights map[string]float32) []float32 {
+	var sum []float32
+	for _, f := range database.MetadataFields {
+		vec := vecs[f]
+		if vec == nil || weights[f] == 0 {
+			continue
+		}
+		if sum == nil {
+			sum = make([]float32, len(vec))
+		}
+		vec32.AddScaled(sum, vec, weights[f])
+	}
+	if sum == nil || vec32.Norm(sum) == 0 {
+		return nil
+	}
+	vec32.Normalize(sum)
+	return sum
+}
+
+// recombine replaces the metadata vectors with the combinations of the stored
+// field vectors.
+func recombine(db *sql.DB, weights map[string]float32) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+	SELECT dataset_id, field, emb FROM metadata_field_vectors ORDER BY dataset_id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	combined := make(map[string][]float32)
+	var datasetID string
+	vecs := make(map[string][]float32)
+
+	flush := func() {
+		if vec := combineFields(vecs, weights); vec != nil {
+			combined[datasetID] = vec
+		}
+		vecs = make(map[string][]float32)
+	}
+	for rows.Next() {
+		var id, field string
+		var emb []byte
+		if err := rows.Scan(&id, &field, &emb); err != nil {
+			return err
+		}
+		if id != datasetID {
+			flush()
+			datasetID = id
+		}
+		if vecs[field], err = vec32.FromBytes(emb); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	flush()
+
+	stmt, err := tx.Prepare(`
+	INSERT OR REPLACE INTO metadata_vectors (dataset_id, emb) VALUES (?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for id, vec := range combined {
+		if _, err := stmt.Exec(id, vec32.Bytes(vec)); err != nil {
+			return err
+		}
+	}
+	log.Printf("combined %d metadata vectors", len(combined))
+	return tx.Commit()
+}
+
+// wordWeights computes the weights of the words of the metadata in the
+// metadata table. Returns nil if the words are not weighted.
+func wordWeights(tx *sql.Tx) (*wordemb.Weights, error) {
+	if *weighting == "none" {
+		return nil, nil
+	}
+	w, err := wordemb.NewWeights(*weighting)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(`
+	SELECT name, description, attribution, categories, tags FROM metadata`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, description, attribution, categories, tags string
+		if err := rows.Scan(&name, &description, &attribution, &categories, &tags); err != nil {
+			return nil, err
+		}
+		// Categories and tags are stored comma-separated.
+		fields := map[string]string{
+			"name":        name,
+			"description": description,
+			"attribution": attribution,
+			"categories":  strings.ReplaceAll(categories, ",", " "),
+			"tags":        strings.ReplaceAll(tags, ",", " "),
+		}
+		text := make([]string, len(database.MetadataFields))
+		for i, f := range database.MetadataFields {
+			text[i] = fields[f]
+		}
+		w.Count(text)
+	}
+	return w, rows.Err()
 }
 
 func main() {
+	flag.Parse()
+
+	weights, err := parseFieldWeights(*fieldWeights)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	db, err := sql.Open("sqlite3", config.DatabasePath())
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
-	ft := fasttext.NewFastText(config.FasttextPath())
-	defer ft.Close()
+	if *combine {
+		if err := recombine(db, weights); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	emb, err := wordemb.Open(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer emb.Close()
 
 	tx, err := db.Begin()
 	if err != nil {
@@ -112,6 +292,22 @@ func main() {
 	}
 	defer metadataStmt.Close()
 
+	ftsStmt, err := tx.Prepare(`
+	INSERT INTO metadata_fts (
+		dataset_id,
+		name,
+		description,
+		attribution,
+		categories,
+		tags
+	)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer ftsStmt.Close()
+
 	vectorStmt, err := tx.Prepare(`
 	INSERT INTO metadata_vectors (dataset_id, emb) VALUES (?, ?)`)
 	if err != nil {
@@ -119,14 +315,22 @@ func main() {
 	}
 	defer vectorStmt.Close()
 
-	files, err := ioutil.ReadDir(datasetsDir)
+	fieldVectorStmt, err := tx.Prepare(`
+	INSERT INTO metadata_field_vectors (dataset_id, field, emb) VALUES (?, ?, ?)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fieldVectorStmt.Close()
+
+	files, err := ioutil.ReadDir(config.DatasetsDir())
 	if err != nil {
 		log.Fatal(err)
 	}
+	var datasets []*metadata
 
 	for _, f := range files {
 		datasetID := f.Name()
-		path := filepath.Join(datasetsDir, datasetID, "metadata.json")
+		path := filepath.Join(config.DatasetsDir(), datasetID, "metadata.json")
 
 		file, err := os.Open(path)
 		if err != nil {
@@ -155,15 +359,97 @@ func main() {
 		if err != nil {
 			log.Fatalf("dataset %v: %v", datasetID, err)
 		}
-
-		emb, err := metadataVector(ft, &m)
-		if err != nil && err != wordemb.ErrNoEmb {
+		_, err = ftsStmt.Exec(
+			m.Resource.ID,
+			m.Resource.Name,
+			m.Resource.Description,
+			m.Resource.Attribution,
+			strings.Join(m.categories(), " "),
+			strings.Join(m.tags(), " "))
+		if err != nil {
 			log.Fatalf("dataset %v: %v", datasetID, err)
 		}
-		_, err = vectorStmt.Exec(m.Resource.ID, vec32.Bytes(emb))
+		datasets = append(datasets, &m)
+	}
+
+	// The vectors are created once all metadata is stored, since the weights
+	// depend on all of it.
+	w, err := wordWeights(tx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	emb.SetWeights(w)
+
+	// fieldVecs[i] maps the fields of datasets[i] to their vectors.
+	fieldVecs := make([]map[string][]float32, len(datasets))
+	for i := range fieldVecs {
+		fieldVecs[i] = make(map[string][]float32)
+	}
+	for _, f := range database.MetadataFields {
+		texts := make([][]string, len(datasets))
+		for i, m := range datasets {
+			texts[i] = []string{m.field(f)}
+		}
+		vecs, err := emb.EmbedBatch(texts)
 		if err != nil {
-			log.Fatalf("dataset %v: %v", datasetID, err)
+			log.Fatal(err)
+		}
+		for i, vec := range vecs {
+			if vec != nil {
+				fieldVecs[i][f] = vec
+			}
+		}
+	}
+	stats := emb.CacheStats()
+	log.Printf("word vector cache: %d hits, %d misses (%.1f%% hit rate)",
+		stats.Hits, stats.Misses, 100*stats.HitRate())
+
+	// The common component is computed from the metadata vectors, but removed
+	// from the field vectors only, before they are combined. The stored field
+	// vectors are then free of it, and combining them again with -combine
+	// gives the same vectors as here.
+	if w != nil && w.Mode == wordemb.SIF {
+		var combined [][]float32
+		for i := range datasets {
+			if vec := combineFields(fieldVecs[i], weights); vec != nil {
+				combined = append(combined, vec)
+			}
+		}
+		w.ComputeComponent(combined)
+		for i := range datasets {
+			for _, vec := range fieldVecs[i] {
+				w.RemoveComponent(vec)
+				if vec32.Norm(vec) > 0 {
+					vec32.Normalize(vec)
+				}
+			}
+		}
+	}
+	vecs := make([][]float32, len(datasets))
+	for i := range datasets {
+		vecs[i] = combineFields(fieldVecs[i], weights)
+	}
+	for i, m := range datasets {
+		vec := vecs[i]
+		// Metadata none of whose words have an embedding has a zero vector.
+		if vec == nil {
+			vec = make([]float32, emb.Dim())
+		}
+		_, err = vectorStmt.Exec(m.Resource.ID, vec32.Bytes(vec))
+		if err != nil {
+			log.Fatalf("dataset %v: %v", m.Resource.ID, err)
+		}
+		for f, vec := range fieldVecs[i] {
+			_, err = fieldVectorStmt.Exec(m.Resource.ID, f, vec32.Bytes(vec))
+			if err != nil {
+				log.Fatalf("dataset %v: %v", m.Resource.ID, err)
+			}
 		}
 	}
-	tx.Commit()
+	if err := wordemb.StoreWeights(tx, w); err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -15,7 +15,7 @@ import (
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/navigation"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/server"
-	"github.com/ekzhu/go-fasttext"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -41,10 +41,13 @@ func main() {
 	}
 	defer db.Close()
 
-	ft := fasttext.NewFastText(config.FasttextPath())
-	defer ft.Close()
+	emb, err := wordemb.Open(db.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer emb.Close()
 
-	metadataIndex, err := index.BuildMetadataEmbeddingIndex(db)
+	metadataIndex, err := index.BuildMetadataEmbeddingIndex(db, emb.Dim(), nil)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -66,7 +69,7 @@ func main() {
 	s, err := server.New(&server.Config{
 		DevMode:              true,
 		DB:                   db,
-		FastText:             ft,
+		Embedder:             emb,
 		MetadataIndex:        metadataIndex,
 		JoinabilityThreshold: joinabilityThreshold,
 		JoinabilityIndex:     joinabilityIndex,
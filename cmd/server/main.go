@@ -2,18 +2,23 @@
 package main
 
 import (
+	"database/sql"
 	"flag"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/navigation"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/server"
-	"github.com/ekzhu/go-fasttext"
-	"github.com/ekzhu/lshensemble"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -21,11 +26,160 @@ var (
 	orgGamma    = flag.Float64("orggamma", 1.0, "Organization gamma parameter")
 	orgWindow   = flag.Int("orgwin", 1001, "Organization termination window size")
 	noJoinIndex = flag.Bool("nojoin", false, "Disable joinable table search")
+	noFields    = flag.Bool("nofields", false, "Disable field-targeted metadata search")
+	embCache    = flag.Int("embcache", wordemb.DefaultCacheSize, "Maximum number of word vectors cached in memory")
+	preload     = flag.Bool("preload", false, "Preload the word vectors of the metadata and column names into memory")
+	orgCache    = flag.Int("orgcache", 100, "Maximum number of organizations kept in memory")
+	orgTTL      = flag.Duration("orgttl", time.Hour, "Time after which organizations are removed from memory")
+	orgWorkers  = flag.Int("orgworkers", 2, "Number of organizations built concurrently")
+	saveOrgs    = flag.Bool("saveorgs", false, "Save built organizations in the database")
+	maxResults  = flag.Int("maxresults", 500, "Maximum number of results ranked by a search")
+	maxPageSize = flag.Int("maxpagesize", 100, "Maximum number of results in a page")
+	verifyCount = flag.Int("verify", 20, "Number of joinable columns verified by exact containment on request")
+
+	unionColThreshold = flag.Float64("unioncolthreshold", 0.5, "Minimum containment of query columns in unionable table candidates")
+	unionColFraction  = flag.Float64("unioncolfraction", 0.4, "Minimum fraction of query columns shared by unionable table candidates")
+	unionThreshold    = flag.Float64("unionthreshold", 0.5, "Minimum product of the scores of aligned unionable columns")
+	unionMetaK        = flag.Int("unionmetak", 50, "Number of unionable table candidates with similar metadata")
+	unionByMeta       = flag.Bool("unionbymeta", false, "Find unionable table candidates with similar metadata by default")
+	unionNameK        = flag.Int("unionnamek", 100, "Maximum number of unionable table candidates with the same column names")
+
+	metaIndex   = flag.String("metaindex", "Flat", "Faiss index factory description of the metadata embedding index, e.g. IVF1024,Flat or HNSW32")
+	metaParams  = flag.String("metaparams", "", "Search parameters of the metadata embedding index, e.g. nprobe=16 or efSearch=64")
+	labelIndex  = flag.String("labelindex", "Flat", "Faiss index factory description of the category index used to label organizations")
+	labelParams = flag.String("labelparams", "", "Search parameters of the category index used to label organizations")
+
+	updateInterval = flag.Duration("update", 0, "Interval at which changes of the database are applied to the indexes (0 to only apply them on SIGHUP)")
 )
 
 // Containment threshold for joinability index
 const joinabilityThreshold = 0.5
 
+// loadLshIndex loads an LSH Ensemble index from its file in the index
+// directory, or builds it from the database if the file is missing or stale.
+func loadLshIndex(
+	db *database.DB,
+	name, file string,
+	load func(*database.DB, string) (*index.LshIndex, error),
+	build func(*database.DB) (*index.LshIndex, error),
+) (*index.LshIndex, error) {
+	path := filepath.Join(config.IndexDir(), file)
+	idx, err := load(db, path)
+	if err == nil {
+		log.Printf("loaded %s index from %s", name, path)
+		return idx, nil
+	}
+	log.Printf("building %s index: %v", name, err)
+
+	if idx, err = build(db); err != nil {
+		return nil, err
+	}
+	log.Printf("built %s index", name)
+	return idx, nil
+}
+
+// loadMetadataIndex loads a metadata embedding index from its file in the
+// index directory, or builds it from the database if the file cannot be
+// loaded.
+func loadMetadataIndex(
+	db *database.DB,
+	name, file string,
+	cfg *index.FaissConfig,
+	load func(db *database.DB, path string) (*index.MetadataIndex, error),
+	build func(db *database.DB) (*index.MetadataIndex, error),
+) (*index.MetadataIndex, error) {
+	path := filepath.Join(config.IndexDir(), file)
+	idx, err := load(db, path)
+	if err == nil {
+		log.Printf("loaded %s %s index from %s", cfg, name, path)
+		return idx, nil
+	}
+	log.Printf("building %s index: %v", name, err)
+
+	if idx, err = build(db); err != nil {
+		return nil, err
+	}
+	log.Printf("built %s %s index", cfg, name)
+	return idx, nil
+}
+
+// faissConfig returns the configuration of a faiss index with the given index
+// factory description and search parameters.
+func faissConfig(description, params string) (*index.FaissConfig, error) {
+	searchParams, err := index.ParseSearchParams(params)
+	if err != nil {
+		return nil, err
+	}
+	return &index.FaissConfig{Description: description, SearchParams: searchParams}, nil
+}
+
+// updateIndexes applies the changes of the database to the indexes of the
+// server on SIGHUP and, if interval is positive, at every interval.
+func updateIndexes(s *server.Server, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		tick = time.NewTicker(interval).C
+	}
+	for {
+		select {
+		case <-hup:
+			log.Println("SIGHUP: updating indexes")
+		case <-tick:
+		}
+		if err := s.UpdateIndexes(); err != nil {
+			log.Println("updating indexes:", err)
+		}
+	}
+}
+
+// corpusTexts returns the metadata fields and column names of the datasets,
+// whose words are preloaded into the embedding model.
+func corpusTexts(db *database.DB) ([][]string, error) {
+	rows, err := db.Query(`
+	SELECT name, description, attribution, categories, tags FROM metadata`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var texts [][]string
+	for rows.Next() {
+		var name, description, attribution, categories, tags string
+		if err := rows.Scan(&name, &description, &attribution, &categories, &tags); err != nil {
+			return nil, err
+		}
+		text := []string{name, description, attribution}
+		text = append(text, strings.Split(categories, ",")...)
+		text = append(text, strings.Split(tags, ",")...)
+		texts = append(texts, text)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	hasColumns, err := db.HasTable("column_sketches")
+	if err != nil || !hasColumns {
+		return texts, err
+	}
+	rows, err = db.Query(`SELECT DISTINCT column_name FROM column_sketches`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		texts = append(texts, []string{name})
+	}
+	return texts, rows.Err()
+}
+
 func main() {
 	flag.Parse()
 
@@ -40,22 +194,90 @@ func main() {
 	}
 	defer db.Close()
 
-	ft := fasttext.NewFastText(config.FasttextPath())
-	defer ft.Close()
+	emb, err := wordemb.Open(db.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer emb.Close()
+	emb.SetCacheSize(*embCache)
+	if *preload {
+		texts, err := corpusTexts(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := emb.Preload(texts); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("preloaded %d word vectors", emb.CacheStats().Preloaded)
+	}
 
-	metadataIndex, err := index.BuildMetadataEmbeddingIndex(db)
+	// The metadata vectors must have been created with the same model as the
+	// query vectors.
+	dim, err := db.MetadataVectorDim()
+	if err != nil && err != sql.ErrNoRows {
+		log.Fatal(err)
+	}
+	if err == nil && dim != emb.Dim() {
+		log.Fatalf("metadata vectors have dimension %d, but the embedding model has dimension %d; run process_metadata with the embedding model",
+			dim, emb.Dim())
+	}
+
+	metaConf, err := faissConfig(*metaIndex, *metaParams)
+	if err != nil {
+		log.Fatal(err)
+	}
+	labelConf, err := faissConfig(*labelIndex, *labelParams)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	metadataIndex, err := loadMetadataIndex(db, "metadata embedding",
+		index.MetadataIndexFile, metaConf,
+		func(db *database.DB, path string) (*index.MetadataIndex, error) {
+			return index.LoadMetadataEmbeddingIndex(db, path, emb.Dim(), metaConf)
+		},
+		func(db *database.DB) (*index.MetadataIndex, error) {
+			return index.BuildMetadataEmbeddingIndex(db, emb.Dim(), metaConf)
+		})
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Println("built metadata embedding index")
 
-	var joinabilityIndex *lshensemble.LshEnsemble
+	// The field and column pair indexes are built even if their tables are
+	// empty or missing, so that they find the rows added later once updated.
+	var fieldIndexes map[string]*index.MetadataIndex
+	if !*noFields {
+		fieldIndexes = make(map[string]*index.MetadataIndex)
+		for _, field := range database.MetadataFields {
+			field := field
+			fieldIndexes[field], err = loadMetadataIndex(db, "metadata "+field+" embedding",
+				index.MetadataFieldIndexFile(field), metaConf,
+				func(db *database.DB, path string) (*index.MetadataIndex, error) {
+					return index.LoadMetadataFieldIndex(db, field, path, emb.Dim(), metaConf)
+				},
+				func(db *database.DB) (*index.MetadataIndex, error) {
+					return index.BuildMetadataFieldIndex(db, field, emb.Dim(), metaConf)
+				})
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	var joinabilityIndex, columnPairIndex *index.LshIndex
 	if !*noJoinIndex {
-		joinabilityIndex, err = index.BuildJoinabilityIndex(db)
+		joinabilityIndex, err = loadLshIndex(db, "joinability",
+			index.JoinabilityIndexFile,
+			index.LoadJoinabilityIndex, index.BuildJoinabilityIndex)
+		if err != nil {
+			log.Fatal(err)
+		}
+		columnPairIndex, err = loadLshIndex(db, "column pair",
+			index.ColumnPairIndexFile,
+			index.LoadColumnPairIndex, index.BuildColumnPairIndex)
 		if err != nil {
 			log.Fatal(err)
 		}
-		log.Println("built joinability index")
 	}
 
 	orgConf := &navigation.Config{
@@ -63,21 +285,39 @@ func main() {
 		TerminationThreshold: 1e-9,
 		TerminationWindow:    *orgWindow,
 		MaxIters:             1e6,
+		LabelIndex:           *labelConf,
 	}
 
 	s, err := server.New(&server.Config{
-		DevMode:              !releaseMode,
-		DB:                   db,
-		FastText:             ft,
-		MetadataIndex:        metadataIndex,
-		JoinabilityThreshold: joinabilityThreshold,
-		JoinabilityIndex:     joinabilityIndex,
-		OrganizeConfig:       orgConf,
+		DevMode:                 !releaseMode,
+		DB:                      db,
+		Embedder:                emb,
+		MetadataIndex:           metadataIndex,
+		MetadataFieldIndexes:    fieldIndexes,
+		JoinabilityThreshold:    joinabilityThreshold,
+		JoinabilityIndex:        joinabilityIndex,
+		ColumnPairIndex:         columnPairIndex,
+		OrganizeConfig:          orgConf,
+		OrganizationCacheSize:   *orgCache,
+		OrganizationTTL:         *orgTTL,
+		OrganizeWorkers:         *orgWorkers,
+		SaveOrganizations:       *saveOrgs,
+		MaxResults:              *maxResults,
+		MaxPageSize:             *maxPageSize,
+		VerifyResults:           *verifyCount,
+		UnionColumnThreshold:    *unionColThreshold,
+		UnionColumnFraction:     *unionColFraction,
+		UnionScoreThreshold:     *unionThreshold,
+		UnionMetadataCandidates: *unionMetaK,
+		UnionByMetadata:         *unionByMeta,
+		UnionNameCandidates:     *unionNameK,
 	})
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	go updateIndexes(s, *updateInterval)
+
 	port := os.Getenv("SERVERPORT")
 	if port == "" {
 		if releaseMode {
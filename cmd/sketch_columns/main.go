@@ -18,116 +18,91 @@ import (
 	"runtime/pprof"
 
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
-	"github.com/axiomhq/hyperloglog"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/sketch"
 	"github.com/ekzhu/lshensemble"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 const (
-	datasetsDir = "datasets"
-	// Minhash parameters
-	mhSeed = 42
-	mhSize = 256
-	// Number of sample data values
-	sampleSize = 20
 	// Number of worker goroutines
 	numWorkers = 16
 )
 
-type tableSketch struct {
-	datasetID      string
-	columnSketches []*columnSketch
+// datasetSketch holds the sketches of the columns of a dataset and of the
+// pairs of its candidate composite key columns.
+type datasetSketch struct {
+	*sketch.TableSketch
+	Pairs []*sketch.PairSketch
 }
 
-func (s *tableSketch) update(record []string) {
-	if s.columnSketches == nil {
-		for _, v := range record {
-			s.columnSketches = append(s.columnSketches, &columnSketch{
-				columnName:  v,
-				minhash:     lshensemble.NewMinhash(mhSeed, mhSize),
-				hyperloglog: hyperloglog.New(),
-				sample:      make([]string, 0, sampleSize),
-			})
-		}
-	} else {
-		for i, v := range record {
-			s.columnSketches[i].update(v)
-		}
-	}
-}
-
-type columnSketch struct {
-	columnName  string
-	minhash     *lshensemble.Minhash
-	hyperloglog *hyperloglog.Sketch
-	sample      []string
-}
-
-func (s *columnSketch) update(v string) {
-	if v != "" {
-		b := []byte(v)
-		s.minhash.Push(b)
-		s.hyperloglog.Insert(b)
-	}
-
-	if len(s.sample) < sampleSize {
-		s.sample = append(s.sample, v)
-	}
-}
-
-func sketchDataset(path, datasetID string) (*tableSketch, error) {
+func sketchDataset(path, datasetID string) (*datasetSketch, error) {
 	csvfile, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("error sketching %v: %w", datasetID, err)
 	}
 	defer csvfile.Close()
 
-	sketch := tableSketch{datasetID: datasetID}
-	r := csv.NewReader(csvfile)
-	r.LazyQuotes = true
-	r.ReuseRecord = true
-
-	for {
-		record, err := r.Read()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, fmt.Errorf("error sketching %v: %w", datasetID, err)
-		}
-		sketch.update(record)
+	ts, err := sketch.SketchCSV(csvfile, datasetID)
+	if err != nil {
+		return nil, fmt.Errorf("error sketching %v: %w", datasetID, err)
 	}
-	if sketch.columnSketches == nil {
+	if ts == nil {
 		return nil, nil
 	}
-	return &sketch, nil
+	// Read the file again to sketch the pairs of the candidate columns,
+	// which are known once all columns are sketched.
+	if _, err := csvfile.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error sketching %v: %w", datasetID, err)
+	}
+	pairs, err := sketch.SketchColumnPairs(csvfile, ts.PairColumns())
+	if err != nil {
+		return nil, fmt.Errorf("error sketching %v: %w", datasetID, err)
+	}
+	return &datasetSketch{ts, pairs}, nil
 }
 
-func writeSketch(stmt *sql.Stmt, sketch *tableSketch) error {
-	for i, col := range sketch.columnSketches {
-		sample, err := json.Marshal(col.sample)
+func writeSketch(stmt *sql.Stmt, ts *sketch.TableSketch) error {
+	for _, col := range ts.Columns() {
+		sample, err := json.Marshal(col.Sample)
 		if err != nil {
-			return fmt.Errorf("error writing sketch %v: %v", sketch.datasetID, err)
+			return fmt.Errorf("error writing sketch %v: %v", ts.DatasetID, err)
 		}
 		_, err = stmt.Exec(
-			fmt.Sprint(sketch.datasetID, "-", i),
-			sketch.datasetID,
-			col.columnName,
-			col.hyperloglog.Estimate(),
-			lshensemble.SigToBytes(col.minhash.Signature()),
+			col.ColumnID,
+			col.DatasetID,
+			col.ColumnName,
+			col.DistinctCount,
+			lshensemble.SigToBytes(col.Minhash),
 			sample)
 		if err != nil {
-			return fmt.Errorf("error writing sketch %v: %v", sketch.datasetID, err)
+			return fmt.Errorf("error writing sketch %v: %v", ts.DatasetID, err)
+		}
+	}
+	return nil
+}
+
+func writePairSketches(stmt *sql.Stmt, ds *datasetSketch) error {
+	for _, p := range ds.Pairs {
+		pair := p.Pair(ds.DatasetID)
+		_, err := stmt.Exec(
+			pair.PairID,
+			pair.DatasetID,
+			pair.ColumnIDs[0],
+			pair.ColumnIDs[1],
+			pair.DistinctCount,
+			lshensemble.SigToBytes(pair.Minhash))
+		if err != nil {
+			return fmt.Errorf("error writing pair sketches %v: %v", ds.DatasetID, err)
 		}
 	}
 	return nil
 }
 
-func sketchWorker(jobs <-chan string, out chan<- *tableSketch) {
+func sketchWorker(jobs <-chan string, out chan<- *datasetSketch) {
 	for datasetID := range jobs {
 		log.Println("sketching", datasetID)
-		path := filepath.Join(datasetsDir, datasetID, "rows.csv")
-		sketch, err := sketchDataset(path, datasetID)
+		path := filepath.Join(config.DatasetsDir(), datasetID, "rows.csv")
+		ds, err := sketchDataset(path, datasetID)
 		if err != nil {
 			if errors.Is(err, os.ErrNotExist) || errors.Is(err, csv.ErrFieldCount) {
 				log.Println(err)
@@ -135,7 +110,7 @@ func sketchWorker(jobs <-chan string, out chan<- *tableSketch) {
 				log.Fatal(err)
 			}
 		}
-		out <- sketch
+		out <- ds
 	}
 }
 
@@ -156,12 +131,12 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	files, err := ioutil.ReadDir(datasetsDir)
+	files, err := ioutil.ReadDir(config.DatasetsDir())
 	if err != nil {
 		log.Fatal(err)
 	}
 	jobs := make(chan string, len(files))
-	out := make(chan *tableSketch, len(files))
+	out := make(chan *datasetSketch, len(files))
 
 	for i := 0; i < numWorkers; i++ {
 		go sketchWorker(jobs, out)
@@ -192,9 +167,22 @@ func main() {
 	}
 	defer insertStmt.Close()
 
+	insertPairStmt, err := tx.Prepare(`
+	INSERT INTO column_pair_sketches
+	(pair_id, dataset_id, column_id1, column_id2, distinct_count, minhash)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer insertPairStmt.Close()
+
 	for range files {
-		if sketch := <-out; sketch != nil {
-			if err := writeSketch(insertStmt, sketch); err != nil {
+		if ds := <-out; ds != nil {
+			if err := writeSketch(insertStmt, ds.TableSketch); err != nil {
+				log.Fatal(err)
+			}
+			if err := writePairSketches(insertPairStmt, ds); err != nil {
 				log.Fatal(err)
 			}
 		}
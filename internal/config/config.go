@@ -21,3 +21,40 @@ func FasttextPath() string {
 	}
 	return "fasttext.sqlite"
 }
+
+// DatasetsDir returns the path to the directory of crawled datasets.
+// The path is "datasets", or the contents of the OPENDATALINK_DATASETS
+// environment variable if it is set.
+func DatasetsDir() string {
+	if path := os.Getenv("OPENDATALINK_DATASETS"); path != "" {
+		return path
+	}
+	return "datasets"
+}
+
+// IndexDir returns the path to the directory of index files written by
+// build_indexes.
+// The path is "indexes", or the contents of the OPENDATALINK_INDEXES environment
+// variable if it is set.
+func IndexDir() string {
+	if path := os.Getenv("OPENDATALINK_INDEXES"); path != "" {
+		return path
+	}
+	return "indexes"
+}
+
+// GlovePath returns the path to a GloVe text file of word vectors used to
+// embed text instead of the fastText database.
+// The path is the contents of the GLOVE_PATH environment variable, or empty
+// if it is not set.
+func GlovePath() string {
+	return os.Getenv("GLOVE_PATH")
+}
+
+// FasttextBinPath returns the path to a fastText binary (.bin) model whose
+// subword vectors embed words that have no vector.
+// The path is the contents of the FASTTEXT_BIN environment variable, or empty
+// if it is not set.
+func FasttextBinPath() string {
+	return os.Getenv("FASTTEXT_BIN")
+}
@@ -25,14 +25,26 @@ func New(databasePath string) (*DB, error) {
 	return &DB{db}, nil
 }
 
+// HasTable reports whether the database has a table with the given name.
+func (db *DB) HasTable(name string) (bool, error) {
+	var n int
+	err := db.QueryRow(`
+	SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`,
+		name).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
 // ColumnSketch is a row of the column_sketches table.
 type ColumnSketch struct {
-	ColumnID      string
-	DatasetID     string
-	ColumnName    string
-	DistinctCount int
-	Minhash       []uint64
-	Sample        []string
+	ColumnID      string   `json:"column_id"`
+	DatasetID     string   `json:"dataset_id"`
+	ColumnName    string   `json:"column_name"`
+	DistinctCount int      `json:"distinct_count"`
+	Minhash       []uint64 `json:"-"`
+	Sample        []string `json:"sample"`
 }
 
 // ColumnSketch returns the ColumnSketch for the given column ID.
@@ -94,17 +106,46 @@ func (db *DB) DatasetColumns(datasetID string) ([]*ColumnSketch, error) {
 	return cols, nil
 }
 
+// ColumnPairSketch is a row of the column_pair_sketches table.
+type ColumnPairSketch struct {
+	PairID        string    `json:"pair_id"`
+	DatasetID     string    `json:"dataset_id"`
+	ColumnIDs     [2]string `json:"column_ids"`
+	DistinctCount int       `json:"distinct_count"`
+	Minhash       []uint64  `json:"-"`
+}
+
+// ColumnPairSketch returns the ColumnPairSketch for the given pair ID.
+func (db *DB) ColumnPairSketch(pairID string) (*ColumnPairSketch, error) {
+	p := ColumnPairSketch{PairID: pairID}
+	var minhash []byte
+
+	err := db.QueryRow(`
+	SELECT dataset_id, column_id1, column_id2, distinct_count, minhash
+	FROM column_pair_sketches
+	WHERE pair_id = ?`, pairID).Scan(
+		&p.DatasetID, &p.ColumnIDs[0], &p.ColumnIDs[1], &p.DistinctCount, &minhash)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Minhash, err = lshensemble.BytesToSig(minhash); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
 // Metadata is a row of the metadata table.
 type Metadata struct {
-	DatasetID    string
-	Name         string
-	Description  string
-	Attribution  string
-	ContactEmail string
-	UpdatedAt    string
-	Categories   []string
-	Tags         []string
-	Permalink    string
+	DatasetID    string   `json:"dataset_id"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	Attribution  string   `json:"attribution"`
+	ContactEmail string   `json:"contact_email"`
+	UpdatedAt    string   `json:"updated_at"`
+	Categories   []string `json:"categories"`
+	Tags         []string `json:"tags"`
+	Permalink    string   `json:"permalink"`
 }
 
 // DatasetName returns the name of a dataset given its ID.
@@ -171,3 +212,127 @@ func (db *DB) MetadataVector(datasetID string) ([]float32, error) {
 	}
 	return vec, nil
 }
+
+// MetadataFields are the fields of the metadata that have embedding vectors in
+// the metadata_field_vectors table. They are also columns of the metadata and
+// metadata_fts tables.
+var MetadataFields = []string{"name", "description", "attribution", "categories", "tags"}
+
+// MetadataVectorDim returns the dimension of the metadata embedding vectors,
+// which is that of the embedding model they were created with.
+// Returns sql.ErrNoRows if there are no metadata vectors.
+func (db *DB) MetadataVectorDim() (int, error) {
+	var size int
+
+	err := db.QueryRow(`
+	SELECT length(emb) FROM metadata_vectors LIMIT 1`).Scan(&size)
+	if err != nil {
+		return 0, err
+	}
+	return size / 4, nil
+}
+
+// Organization is a row of the organizations table.
+type Organization struct {
+	OrganizationID  string `json:"id"`
+	OrganizationKey string `json:"-"`
+	Name            string `json:"name"`
+	DatasetCount    int    `json:"dataset_count"`
+	CreatedAt       string `json:"created_at"`
+	// JSON encoding of the organization graph.
+	Graph []byte `json:"-"`
+}
+
+// InsertOrganization saves an organization.
+func (db *DB) InsertOrganization(o *Organization) error {
+	_, err := db.Exec(`
+	INSERT INTO organizations (
+		organization_id,
+		organization_key,
+		name,
+		dataset_count,
+		created_at,
+		graph
+	)
+	VALUES (?, ?, ?, ?, ?, ?)`,
+		o.OrganizationID,
+		o.OrganizationKey,
+		o.Name,
+		o.DatasetCount,
+		o.CreatedAt,
+		o.Graph)
+	return err
+}
+
+// Organization returns the organization with the given ID.
+func (db *DB) Organization(organizationID string) (*Organization, error) {
+	return db.scanOrganization(db.QueryRow(`
+	SELECT
+		organization_id,
+		organization_key,
+		name,
+		dataset_count,
+		created_at,
+		graph
+	FROM organizations
+	WHERE organization_id = ?`, organizationID))
+}
+
+// OrganizationByKey returns the most recent organization with the given key.
+func (db *DB) OrganizationByKey(key string) (*Organization, error) {
+	return db.scanOrganization(db.QueryRow(`
+	SELECT
+		organization_id,
+		organization_key,
+		name,
+		dataset_count,
+		created_at,
+		graph
+	FROM organizations
+	WHERE organization_key = ?
+	ORDER BY created_at DESC
+	LIMIT 1`, key))
+}
+
+func (db *DB) scanOrganization(row *sql.Row) (*Organization, error) {
+	var o Organization
+	err := row.Scan(
+		&o.OrganizationID,
+		&o.OrganizationKey,
+		&o.Name,
+		&o.DatasetCount,
+		&o.CreatedAt,
+		&o.Graph)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// Organizations returns the saved organizations without their graphs, most
+// recent first.
+func (db *DB) Organizations() ([]*Organization, error) {
+	var orgs []*Organization
+
+	rows, err := db.Query(`
+	SELECT organization_id, name, dataset_count, created_at
+	FROM organizations
+	ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o Organization
+		err := rows.Scan(&o.OrganizationID, &o.Name, &o.DatasetCount, &o.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, &o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
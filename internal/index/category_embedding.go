@@ -4,20 +4,21 @@ import (
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
 	"github.com/DataIntelligenceCrew/go-faiss"
-	"github.com/ekzhu/go-fasttext"
 
 	"strings"
 )
 
 // CategoryIndex is an index over the category embedding vectors.
 type CategoryIndex struct {
-	idx *faiss.IndexFlat
+	idx *faiss.Index
 	// Maps ID of vector in index to category name.
 	idMap []string
 }
 
-// BuildCategoryEmbeddingIndex builds a CategoryIndex.
-func BuildCategoryEmbeddingIndex(db *database.DB, ft *fasttext.FastText) (*CategoryIndex, error) {
+// BuildCategoryEmbeddingIndex builds a CategoryIndex of the category embedding
+// vectors created by emb, with the faiss index selected by cfg, which may be
+// nil for a flat index.
+func BuildCategoryEmbeddingIndex(db *database.DB, emb wordemb.Embedder, cfg *FaissConfig) (*CategoryIndex, error) {
 	categories := make(map[string]bool) // Set of all categories
 
 	rows, err := db.Query(`SELECT categories FROM metadata`)
@@ -41,7 +42,14 @@ func BuildCategoryEmbeddingIndex(db *database.DB, ft *fasttext.FastText) (*Categ
 		return nil, err
 	}
 
-	index, err := faiss.NewIndexFlatIP(300)
+	var names []string
+	var texts [][]string
+
+	for category := range categories {
+		names = append(names, category)
+		texts = append(texts, []string{category})
+	}
+	embs, err := emb.EmbedBatch(texts)
 	if err != nil {
 		return nil, err
 	}
@@ -49,20 +57,27 @@ func BuildCategoryEmbeddingIndex(db *database.DB, ft *fasttext.FastText) (*Categ
 	var idMap []string
 	var vecs []float32
 
-	for category := range categories {
-		vec, err := wordemb.Vector(ft, []string{category})
-		if err != nil {
-			if err == wordemb.ErrNoEmb {
-				continue
-			}
-			return nil, err
+	for i, vec := range embs {
+		// Skip categories none of whose words have an embedding.
+		if vec == nil {
+			continue
 		}
-
-		idMap = append(idMap, category)
+		idMap = append(idMap, names[i])
 		vecs = append(vecs, vec...)
 	}
 
-	if err := index.Add(vecs); err != nil {
+	index, err := cfg.newIndex(emb.Dim(), func() ([]float32, error) {
+		return vecs, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, len(idMap))
+	for i := range ids {
+		ids[i] = int64(i)
+	}
+	if err := index.AddWithIDs(vecs, ids); err != nil {
+		index.Delete()
 		return nil, err
 	}
 
@@ -0,0 +1,130 @@
+package index
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/go-faiss"
+)
+
+// FaissConfig selects the faiss index of an embedding index.
+// The zero value selects an exact (flat) index.
+type FaissConfig struct {
+	// Faiss index factory description of the index, such as "Flat",
+	// "IVF1024,Flat", "HNSW32" or "IVF256,PQ32". "Flat" is used if it is
+	// empty. Indexes that need training are trained on the indexed vectors.
+	Description string
+	// Search parameters of the index, such as nprobe for IVF indexes and
+	// efSearch for HNSW indexes.
+	SearchParams map[string]float64
+}
+
+func (cfg *FaissConfig) description() string {
+	if cfg == nil || cfg.Description == "" {
+		return "Flat"
+	}
+	return cfg.Description
+}
+
+// isIVF reports whether the index is an inverted file index, which supports
+// adding and removing vectors with IDs.
+func (cfg *FaissConfig) isIVF() bool {
+	return strings.HasPrefix(cfg.description(), "IVF")
+}
+
+// canRemove reports whether vectors can be removed from the index.
+// HNSW and PQ indexes do not support removal.
+func (cfg *FaissConfig) canRemove() bool {
+	return cfg.isIVF() || cfg.description() == "Flat"
+}
+
+// factoryDescription returns the faiss index factory description of the
+// index. Indexes other than IVF indexes are wrapped in an IDMap index, which
+// maps IDs to their vectors.
+func (cfg *FaissConfig) factoryDescription() string {
+	if cfg.isIVF() {
+		return cfg.description()
+	}
+	return "IDMap," + cfg.description()
+}
+
+// newIndex creates an inner product index of dim-dimensional vectors.
+// If the index needs training, it is trained on the vectors returned by
+// training.
+func (cfg *FaissConfig) newIndex(dim int, training func() ([]float32, error)) (*faiss.Index, error) {
+	index, err := faiss.IndexFactory(dim, cfg.factoryDescription(), faiss.MetricInnerProduct)
+	if err != nil {
+		return nil, err
+	}
+	if !index.IsTrained() {
+		vecs, err := training()
+		if err != nil {
+			index.Delete()
+			return nil, err
+		}
+		if err := index.Train(vecs); err != nil {
+			index.Delete()
+			return nil, fmt.Errorf("training %s index on %d vectors: %v",
+				cfg.description(), len(vecs)/dim, err)
+		}
+	}
+	if cfg != nil && len(cfg.SearchParams) > 0 {
+		ps, err := faiss.NewParameterSpace()
+		if err != nil {
+			index.Delete()
+			return nil, err
+		}
+		defer ps.Delete()
+
+		for name, v := range cfg.SearchParams {
+			if err := ps.SetIndexParameter(index, name, v); err != nil {
+				index.Delete()
+				return nil, fmt.Errorf("setting %s of %s index: %v",
+					name, cfg.description(), err)
+			}
+		}
+	}
+	return index, nil
+}
+
+// String returns the description and search parameters of the index.
+func (cfg *FaissConfig) String() string {
+	if cfg == nil || len(cfg.SearchParams) == 0 {
+		return cfg.description()
+	}
+	return cfg.description() + " " + FormatSearchParams(cfg.SearchParams)
+}
+
+// ParseSearchParams parses search parameters formatted as comma-separated
+// name=value pairs, such as "nprobe=16,efSearch=64".
+func ParseSearchParams(s string) (map[string]float64, error) {
+	params := make(map[string]float64)
+	if s == "" {
+		return params, nil
+	}
+	for _, p := range strings.Split(s, ",") {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("invalid search parameter %q", p)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid search parameter %q", p)
+		}
+		params[strings.TrimSpace(kv[0])] = v
+	}
+	return params, nil
+}
+
+// FormatSearchParams formats search parameters as parsed by
+// ParseSearchParams, sorted by name.
+func FormatSearchParams(params map[string]float64) string {
+	pairs := make([]string, 0, len(params))
+	for name, v := range params {
+		pairs = append(pairs, name+"="+strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
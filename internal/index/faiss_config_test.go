@@ -0,0 +1,55 @@
+package index
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSearchParams(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{"", map[string]float64{}, false},
+		{"nprobe=16", map[string]float64{"nprobe": 16}, false},
+		{"nprobe=16, efSearch=64.5", map[string]float64{"nprobe": 16, "efSearch": 64.5}, false},
+		{"nprobe", nil, true},
+		{"=16", nil, true},
+		{"nprobe=x", nil, true},
+	} {
+		got, err := ParseSearchParams(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSearchParams(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSearchParams(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+	if got := FormatSearchParams(map[string]float64{"nprobe": 16, "efSearch": 64.5}); got != "efSearch=64.5,nprobe=16" {
+		t.Errorf("FormatSearchParams() = %q", got)
+	}
+}
+
+func TestFaissConfig(t *testing.T) {
+	for _, tt := range []struct {
+		cfg       *FaissConfig
+		factory   string
+		canRemove bool
+	}{
+		{nil, "IDMap,Flat", true},
+		{&FaissConfig{}, "IDMap,Flat", true},
+		{&FaissConfig{Description: "IVF1024,Flat"}, "IVF1024,Flat", true},
+		{&FaissConfig{Description: "IVF256,PQ32"}, "IVF256,PQ32", true},
+		{&FaissConfig{Description: "HNSW32"}, "IDMap,HNSW32", false},
+		{&FaissConfig{Description: "PQ32"}, "IDMap,PQ32", false},
+	} {
+		if got := tt.cfg.factoryDescription(); got != tt.factory {
+			t.Errorf("%v: factoryDescription() = %q, want %q", tt.cfg, got, tt.factory)
+		}
+		if got := tt.cfg.canRemove(); got != tt.canRemove {
+			t.Errorf("%v: canRemove() = %v, want %v", tt.cfg, got, tt.canRemove)
+		}
+	}
+}
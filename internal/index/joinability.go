@@ -1,6 +1,9 @@
 package index
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
 	"github.com/ekzhu/lshensemble"
 )
@@ -15,42 +18,223 @@ const (
 	maxK = 4
 )
 
+// lshSource is a table of sketches indexed by an LSH Ensemble index.
+type lshSource struct {
+	table string
+	// Column of the keys of the sketches.
+	key string
+	// Condition on the sketches to index, empty for all sketches.
+	where string
+}
+
+var (
+	columnSketches     = &lshSource{table: "column_sketches", key: "column_id"}
+	columnPairSketches = &lshSource{
+		table: "column_pair_sketches",
+		key:   "pair_id",
+		where: "distinct_count > 0",
+	}
+)
+
+// query returns a query selecting the rowid, key, distinct count and minhash
+// signature of the sketches that satisfy the condition of the source and cond,
+// which may be empty.
+func (src *lshSource) query(cond string) string {
+	query := fmt.Sprintf(`SELECT rowid, %s, distinct_count, minhash FROM %s`,
+		src.key, src.table)
+	var conds []string
+	for _, c := range []string{src.where, cond} {
+		if c != "" {
+			conds = append(conds, c)
+		}
+	}
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	return query
+}
+
 // BuildJoinabilityIndex builds an LSH Ensemble index on the dataset columns.
-func BuildJoinabilityIndex(db *database.DB) (*lshensemble.LshEnsemble, error) {
-	var domainRecords []*lshensemble.DomainRecord
+func BuildJoinabilityIndex(db *database.DB) (*LshIndex, error) {
+	return buildLshIndex(db, columnSketches)
+}
+
+// BuildColumnPairIndex builds an LSH Ensemble index on the column pairs
+// sketched as candidate composite keys. The index is empty if there are no
+// column pairs, and finds the pairs sketched later once updated.
+func BuildColumnPairIndex(db *database.DB) (*LshIndex, error) {
+	return buildLshIndex(db, columnPairSketches)
+}
+
+// WriteJoinabilityIndex writes the column sketches indexed by the joinability
+// index to the file at path, to be loaded by LoadJoinabilityIndex.
+func WriteJoinabilityIndex(db *database.DB, path string) error {
+	return writeLshIndex(db, columnSketches, path)
+}
+
+// LoadJoinabilityIndex builds the joinability index from the file at path.
+// Returns ErrStaleIndex if column_sketches has changed since the file was
+// written.
+func LoadJoinabilityIndex(db *database.DB, path string) (*LshIndex, error) {
+	return loadLshIndex(db, columnSketches, path)
+}
+
+// WriteColumnPairIndex writes the column pair sketches indexed by the column
+// pair index to the file at path, to be loaded by LoadColumnPairIndex.
+func WriteColumnPairIndex(db *database.DB, path string) error {
+	return writeLshIndex(db, columnPairSketches, path)
+}
+
+// LoadColumnPairIndex builds the column pair index from the file at path.
+// Returns ErrStaleIndex if column_pair_sketches has changed since the file was
+// written.
+func LoadColumnPairIndex(db *database.DB, path string) (*LshIndex, error) {
+	return loadLshIndex(db, columnPairSketches, path)
+}
+
+func lshChecksum(db *database.DB, src *lshSource) (string, error) {
+	return tableChecksum(db, src.table, src.where,
+		[]string{src.key, "distinct_count", "minhash"}, mhSize, numPart, maxK)
+}
+
+// buildLshIndex builds an LshIndex on the sketches of src.
+func buildLshIndex(db *database.DB, src *lshSource) (*LshIndex, error) {
+	// The checksum is computed first so that changes made while the index is
+	// built are applied by the next update.
+	sum, err := lshChecksum(db, src)
+	if err != nil {
+		return nil, err
+	}
+	if sum == "" {
+		// The table does not exist yet.
+		return newLshIndex(src, sum, nil, make(map[string]int64))
+	}
+	domainRecords, rowids, err := queryDomainRecords(db,
+		src.query("")+` ORDER BY distinct_count`)
+	if err != nil {
+		return nil, err
+	}
+	return newLshIndex(src, sum, domainRecords, rowids)
+}
 
-	rows, err := db.Query(`
-	SELECT column_id, distinct_count, minhash
-	FROM column_sketches
-	ORDER BY distinct_count
-	`)
+// writeLshIndex writes the sketches of src to an index file, sorted by
+// distinct count. The signatures are written as stored in the database.
+func writeLshIndex(db *database.DB, src *lshSource, path string) error {
+	// The checksum is computed first so that changes made while the file is
+	// written make it stale.
+	sum, err := lshChecksum(db, src)
+	if err != nil {
+		return err
+	}
+	w, err := createIndexFile(path, sum, mhSize*lshensemble.HashValueSize)
+	if err != nil {
+		return err
+	}
+	if err := func() error {
+		rows, err := db.Query(src.query("") + ` ORDER BY distinct_count`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var rowid int64
+			var key string
+			var distinctCount int
+			var minhash []byte
+
+			if err := rows.Scan(&rowid, &key, &distinctCount, &minhash); err != nil {
+				return err
+			}
+			if err := w.Add(key, rowid, distinctCount, minhash); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	}(); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Close()
+}
+
+// loadLshIndex builds an LshIndex from the sketches in an index file. The LSH
+// Ensemble library does not expose its hash tables, so they are rebuilt, but
+// the sketches are neither queried nor sorted.
+func loadLshIndex(db *database.DB, src *lshSource, path string) (*LshIndex, error) {
+	sum, err := lshChecksum(db, src)
+	if err != nil {
+		return nil, err
+	}
+	f, err := openIndexFile(path, sum)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
+
+	domainRecords := make([]*lshensemble.DomainRecord, len(f.Keys))
+	rowids := make(map[string]int64)
+
+	for i, key := range f.Keys {
+		sig, err := lshensemble.BytesToSig(f.entry(i))
+		if err != nil {
+			return nil, err
+		}
+		domainRecords[i] = &lshensemble.DomainRecord{
+			Key:       key,
+			Size:      f.Sizes[i],
+			Signature: sig,
+		}
+		rowids[key] = f.Rowids[i]
+	}
+	return newLshIndex(src, sum, domainRecords, rowids)
+}
+
+// queryDomainRecords returns the domain records selected by query, whose
+// columns are the rowid, the key, the distinct count and the minhash
+// signature, and the rowids of the keys.
+func queryDomainRecords(db *database.DB, query string, args ...interface{}) ([]*lshensemble.DomainRecord, map[string]int64, error) {
+	var domainRecords []*lshensemble.DomainRecord
+	rowids := make(map[string]int64)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var columnID string
+		var rowid int64
+		var key string
 		var distinctCount int
 		var minhash []byte
 
-		if err = rows.Scan(&columnID, &distinctCount, &minhash); err != nil {
-			return nil, err
+		if err = rows.Scan(&rowid, &key, &distinctCount, &minhash); err != nil {
+			return nil, nil, err
 		}
 		sig, err := lshensemble.BytesToSig(minhash)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		domainRecords = append(domainRecords, &lshensemble.DomainRecord{
-			Key:       columnID,
+			Key:       key,
 			Size:      distinctCount,
 			Signature: sig,
 		})
+		rowids[key] = rowid
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	return domainRecords, rowids, nil
+}
 
+// bootstrap builds an LSH Ensemble index on domain records sorted by size.
+// Returns nil if there are no records.
+func bootstrap(domainRecords []*lshensemble.DomainRecord) (*lshensemble.LshEnsemble, error) {
+	if len(domainRecords) == 0 {
+		return nil, nil
+	}
 	index, err := lshensemble.BootstrapLshEnsembleEquiDepth(
 		numPart, mhSize, maxK, len(domainRecords), lshensemble.Recs2Chan(domainRecords))
 	if err != nil {
@@ -0,0 +1,196 @@
+package index
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/ekzhu/lshensemble"
+)
+
+// The LshIndex is rebuilt when the number of sketches added or removed since
+// it was built exceeds this fraction of the number of sketches it was built
+// with.
+const maxDeltaFraction = 0.25
+
+// LshIndex is an LSH Ensemble index on the sketches of a table, which can be
+// updated with the changes of the table.
+//
+// The LSH Ensemble library can neither remove domains nor add domains to a
+// bootstrapped index. The sketches added or replaced since the index was
+// built are indexed by a second, small LSH Ensemble index that is rebuilt on
+// each update, and the keys of the sketches removed or replaced since are
+// filtered from the results of the first.
+type LshIndex struct {
+	src *lshSource
+
+	mu sync.RWMutex // Guards base, delta and removed
+	// Index of the sketches the index was built with.
+	base *lshensemble.LshEnsemble
+	// Index of the sketches added since.
+	delta *lshensemble.LshEnsemble
+	// Keys removed from base. The map is replaced, not modified, on update.
+	removed map[string]bool
+
+	// Only accessed by Update, which holds updateMu.
+	updateMu sync.Mutex
+	checksum string
+	baseSize int
+	// Maps keys to the rowids of their sketches.
+	rowids map[string]int64
+	// Sketches indexed by delta.
+	added map[string]*lshensemble.DomainRecord
+}
+
+// newLshIndex builds an LshIndex on domain records sorted by size.
+func newLshIndex(src *lshSource, checksum string, domainRecords []*lshensemble.DomainRecord, rowids map[string]int64) (*LshIndex, error) {
+	base, err := bootstrap(domainRecords)
+	if err != nil {
+		return nil, err
+	}
+	return &LshIndex{
+		src:      src,
+		base:     base,
+		removed:  make(map[string]bool),
+		checksum: checksum,
+		baseSize: len(domainRecords),
+		rowids:   rowids,
+		added:    make(map[string]*lshensemble.DomainRecord),
+	}, nil
+}
+
+// Query returns the candidate keys of the sketches whose estimated containment
+// of the query is at least threshold, as lshensemble.LshEnsemble.Query.
+// Closing done cancels the query.
+func (idx *LshIndex) Query(sig []uint64, size int, threshold float64, done <-chan struct{}) <-chan interface{} {
+	idx.mu.RLock()
+	base, delta, removed := idx.base, idx.delta, idx.removed
+	idx.mu.RUnlock()
+
+	out := make(chan interface{})
+	go func() {
+		defer close(out)
+		for _, lsh := range []*lshensemble.LshEnsemble{base, delta} {
+			if lsh == nil {
+				continue
+			}
+			for key := range lsh.Query(sig, size, threshold, done) {
+				if lsh == base && removed[key.(string)] {
+					continue
+				}
+				select {
+				case out <- key:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Update applies the changes of the table of the sketches since the index was
+// built or last updated. Sketches are identified by their rowids, so a sketch
+// that is replaced gets a new rowid and counts as both removed and added.
+// Queries made during the update use the index as it was before.
+// Returns the numbers of sketches added and removed.
+func (idx *LshIndex) Update(db *database.DB) (added, removed int, err error) {
+	idx.updateMu.Lock()
+	defer idx.updateMu.Unlock()
+
+	sum, err := lshChecksum(db, idx.src)
+	if err != nil || sum == idx.checksum {
+		return 0, 0, err
+	}
+	rowids, err := queryRowids(db, idx.src.table, idx.src.key, idx.src.where)
+	if err != nil {
+		return 0, 0, err
+	}
+	var removedKeys []string
+	var changed []interface{}
+
+	for key, rowid := range idx.rowids {
+		if r, ok := rowids[key]; !ok || r != rowid {
+			removedKeys = append(removedKeys, key)
+		}
+	}
+	for key, rowid := range rowids {
+		if r, ok := idx.rowids[key]; !ok || r != rowid {
+			changed = append(changed, rowid)
+		}
+	}
+	// If no rowid changed, sketches were rewritten in place, or rows of the
+	// table that are not indexed changed, which only a rebuild tells apart.
+	if len(removedKeys) == 0 && len(changed) == 0 ||
+		float64(len(idx.added)+len(idx.removed)+len(removedKeys)+len(changed)) >
+			maxDeltaFraction*float64(idx.baseSize) {
+		rebuilt, err := buildLshIndex(db, idx.src)
+		if err != nil {
+			return 0, 0, err
+		}
+		idx.mu.Lock()
+		idx.base, idx.delta, idx.removed = rebuilt.base, nil, rebuilt.removed
+		idx.mu.Unlock()
+		idx.checksum, idx.baseSize = rebuilt.checksum, rebuilt.baseSize
+		idx.rowids, idx.added = rebuilt.rowids, rebuilt.added
+		return len(changed), len(removedKeys), nil
+	}
+
+	var records []*lshensemble.DomainRecord
+	changedRowids := make(map[string]int64)
+
+	for len(changed) > 0 {
+		n := len(changed)
+		if n > maxQueryParams {
+			n = maxQueryParams
+		}
+		recs, r, err := queryDomainRecords(db,
+			idx.src.query("rowid IN ("+queryParams(n)+")"), changed[:n]...)
+		if err != nil {
+			return 0, 0, err
+		}
+		records = append(records, recs...)
+		for key, rowid := range r {
+			changedRowids[key] = rowid
+		}
+		changed = changed[n:]
+	}
+
+	newRemoved := make(map[string]bool, len(idx.removed)+len(removedKeys))
+	for key := range idx.removed {
+		newRemoved[key] = true
+	}
+	newAdded := make(map[string]*lshensemble.DomainRecord, len(idx.added)+len(records))
+	for key, rec := range idx.added {
+		newAdded[key] = rec
+	}
+	for _, key := range removedKeys {
+		newRemoved[key] = true
+		delete(newAdded, key)
+	}
+	for _, rec := range records {
+		newAdded[rec.Key.(string)] = rec
+	}
+	deltaRecords := make([]*lshensemble.DomainRecord, 0, len(newAdded))
+	for _, rec := range newAdded {
+		deltaRecords = append(deltaRecords, rec)
+	}
+	sort.Sort(lshensemble.BySize(deltaRecords))
+
+	delta, err := bootstrap(deltaRecords)
+	if err != nil {
+		return 0, 0, err
+	}
+	idx.mu.Lock()
+	idx.delta, idx.removed = delta, newRemoved
+	idx.mu.Unlock()
+
+	idx.checksum, idx.added = sum, newAdded
+	for _, key := range removedKeys {
+		delete(idx.rowids, key)
+	}
+	for key, rowid := range changedRowids {
+		idx.rowids[key] = rowid
+	}
+	return len(records), len(removedKeys), nil
+}
@@ -0,0 +1,67 @@
+package index
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestLshIndexUpdate(t *testing.T) {
+	db := newColumnSketchDB(t)
+	defer db.Close()
+
+	// Columns a-i contain the first 10*(i+1) multiples of 7.
+	var values []int
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 10; j++ {
+			values = append(values, 7*len(values))
+		}
+		insertColumnSketch(t, db, "a-"+strconv.Itoa(i), values...)
+	}
+	idx, err := BuildJoinabilityIndex(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := values[:50]
+	sig := minhash(query...)
+	before := queryAll(idx, sig, len(query))
+
+	// b-0 contains the query, a-19 is removed and a-18 replaced by a column
+	// disjoint from the query.
+	insertColumnSketch(t, db, "b-0", query...)
+	_, err = db.Exec(`DELETE FROM column_sketches WHERE column_id IN ('a-18', 'a-19')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insertColumnSketch(t, db, "a-18", 1, 2, 3)
+
+	added, removed, err := idx.Update(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 2 || removed != 2 {
+		t.Errorf("Update() = %d added, %d removed, want 2, 2", added, removed)
+	}
+	var want []string
+	for _, key := range before {
+		if key != "a-18" && key != "a-19" {
+			want = append(want, key)
+		}
+	}
+	want = append(want, "b-0")
+	got := queryAll(idx, sig, len(query))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("after update, query returned %v, want %v", got, want)
+	}
+
+	rebuilt, err := BuildJoinabilityIndex(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := queryAll(rebuilt, sig, len(query)); !reflect.DeepEqual(got, want) {
+		t.Errorf("after update, query returned %v, rebuilt index returned %v", got, want)
+	}
+	if added, removed, err := idx.Update(db); added != 0 || removed != 0 || err != nil {
+		t.Errorf("second Update() = %d, %d, %v, want 0, 0, nil", added, removed, err)
+	}
+}
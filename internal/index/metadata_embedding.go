@@ -1,56 +1,445 @@
 package index
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
 	"github.com/DataIntelligenceCrew/go-faiss"
 )
 
-// MetadataIndex is an index over the metadata embedding vectors.
+// Number of vectors added to a faiss index at a time when loading an index
+// file.
+const loadBatchSize = 4096
+
+// vectorSource is a table of metadata embedding vectors indexed by a
+// MetadataIndex, with dataset_id and emb columns.
+type vectorSource struct {
+	table string
+	// Condition on the vectors to index, empty for all vectors.
+	where string
+}
+
+var metadataSource = &vectorSource{table: "metadata_vectors"}
+
+// fieldVectors returns the source of the vectors of a metadata field.
+func fieldVectors(field string) (*vectorSource, error) {
+	for _, f := range database.MetadataFields {
+		if f == field {
+			return &vectorSource{
+				table: "metadata_field_vectors",
+				where: fmt.Sprintf("field = '%s'", field),
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid metadata field %q", field)
+}
+
+// query returns a query selecting the rowid, dataset ID and vector of the
+// vectors that satisfy the condition of the source and cond, which may be
+// empty.
+func (src *vectorSource) query(cond string) string {
+	query := "SELECT rowid, dataset_id, emb FROM " + src.table
+	var conds []string
+	for _, c := range []string{src.where, cond} {
+		if c != "" {
+			conds = append(conds, c)
+		}
+	}
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	return query
+}
+
+// MetadataIndex is an index over the metadata embedding vectors, or over the
+// vectors of a metadata field.
+//
+// The IDs of the vectors in the faiss index are the rowids of the vectors in
+// their table, so that vectors can be added and removed as the table changes.
 type MetadataIndex struct {
-	idx *faiss.IndexFlat
+	src *vectorSource
+	// Dimension of the vectors.
+	dim    int
+	config *FaissConfig
+
+	mu  sync.RWMutex // Guards idx and idMap
+	idx *faiss.Index
 	// Maps ID of vector in index to dataset ID.
-	idMap []string
+	idMap map[int64]string
+
+	// Only accessed by Update, which holds updateMu.
+	updateMu sync.Mutex
+	checksum string
 }
 
-// BuildMetadataEmbeddingIndex builds a MetadataIndex.
-func BuildMetadataEmbeddingIndex(db *database.DB) (*MetadataIndex, error) {
-	index, err := faiss.NewIndexFlatIP(300)
+// newMetadataIndex creates an empty MetadataIndex of dim-dimensional vectors.
+// If the index needs training, it is trained on the vectors returned by
+// training.
+func newMetadataIndex(src *vectorSource, dim int, cfg *FaissConfig, checksum string, training func() ([]float32, error)) (*MetadataIndex, error) {
+	index, err := cfg.newIndex(dim, training)
 	if err != nil {
 		return nil, err
 	}
+	return &MetadataIndex{
+		src:      src,
+		dim:      dim,
+		config:   cfg,
+		idx:      index,
+		idMap:    make(map[int64]string),
+		checksum: checksum,
+	}, nil
+}
 
-	rows, err := db.Query(`SELECT dataset_id, emb FROM metadata_vectors`)
+func metadataChecksum(db *database.DB, src *vectorSource, dim int) (string, error) {
+	return tableChecksum(db, src.table, src.where, []string{"dataset_id", "emb"}, dim)
+}
+
+// BuildMetadataEmbeddingIndex builds a MetadataIndex of the dim-dimensional
+// metadata embedding vectors with the faiss index selected by cfg, which may
+// be nil for a flat index. dim is the dimension of the embedding model the
+// vectors were created with.
+func BuildMetadataEmbeddingIndex(db *database.DB, dim int, cfg *FaissConfig) (*MetadataIndex, error) {
+	return buildMetadataIndex(db, metadataSource, dim, cfg)
+}
+
+// BuildMetadataFieldIndex builds a MetadataIndex of the dim-dimensional
+// embedding vectors of a metadata field, one of database.MetadataFields, with
+// the faiss index selected by cfg, which may be nil for a flat index.
+func BuildMetadataFieldIndex(db *database.DB, field string, dim int, cfg *FaissConfig) (*MetadataIndex, error) {
+	src, err := fieldVectors(field)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return buildMetadataIndex(db, src, dim, cfg)
+}
 
-	var idMap []string
-	var vecs []float32
+func buildMetadataIndex(db *database.DB, src *vectorSource, dim int, cfg *FaissConfig) (*MetadataIndex, error) {
+	// The checksum is computed first so that changes made while the index is
+	// built are applied by the next update.
+	sum, err := metadataChecksum(db, src, dim)
+	if err != nil {
+		return nil, err
+	}
+	vecs := &metadataVectors{dim: dim}
+	// The checksum is empty if the table does not exist yet.
+	if sum != "" {
+		if err := vecs.query(db, src.query("")); err != nil {
+			return nil, err
+		}
+	}
+	// An index without vectors cannot be trained, so it is flat until vectors
+	// are added, when Update rebuilds it with cfg.
+	faissConf := cfg
+	if len(vecs.ids) == 0 {
+		faissConf = nil
+	}
+	idx, err := newMetadataIndex(src, dim, faissConf, sum, func() ([]float32, error) {
+		return vecs.vecs, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	idx.config = cfg
+	if err := idx.apply(nil, vecs); err != nil {
+		idx.Delete()
+		return nil, err
+	}
+	return idx, nil
+}
+
+// metadataVectors are vectors of a vectorSource.
+type metadataVectors struct {
+	dim        int
+	ids        []int64
+	datasetIDs []string
+	vecs       []float32
+}
+
+// query appends to mv the vectors selected by query, whose columns are
+// the rowid, the dataset ID and the vector.
+// Returns an error if a vector does not have dimension mv.dim.
+func (mv *metadataVectors) query(db *database.DB, query string, args ...interface{}) error {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
 	for rows.Next() {
+		var rowid int64
 		var datasetID string
 		var emb []byte
 
-		if err := rows.Scan(&datasetID, &emb); err != nil {
-			return nil, err
+		if err := rows.Scan(&rowid, &datasetID, &emb); err != nil {
+			return err
 		}
 		vec, err := vec32.FromBytes(emb)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		idMap = append(idMap, datasetID)
-		vecs = append(vecs, vec...)
+		if len(vec) != mv.dim {
+			return fmt.Errorf("metadata vector of dataset %v has dimension %d, want %d",
+				datasetID, len(vec), mv.dim)
+		}
+		mv.ids = append(mv.ids, rowid)
+		mv.datasetIDs = append(mv.datasetIDs, datasetID)
+		mv.vecs = append(mv.vecs, vec...)
+	}
+	return rows.Err()
+}
+
+// apply removes the vectors with the IDs in removed and adds the vectors of
+// added as a single update.
+func (idx *MetadataIndex) apply(removed []int64, added *metadataVectors) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if len(removed) > 0 {
+		sel, err := faiss.NewIDSelectorBatch(removed)
+		if err != nil {
+			return err
+		}
+		defer sel.Delete()
+
+		if _, err := idx.idx.RemoveIDs(sel); err != nil {
+			return err
+		}
+		for _, id := range removed {
+			delete(idx.idMap, id)
+		}
+	}
+	if len(added.ids) > 0 {
+		if err := idx.idx.AddWithIDs(added.vecs, added.ids); err != nil {
+			return err
+		}
+		for i, id := range added.ids {
+			idx.idMap[id] = added.datasetIDs[i]
+		}
+	}
+	return nil
+}
+
+// Update applies the changes of the table of the vectors since the index was
+// built or last updated. Vectors are identified by their rowids, so a vector
+// that is replaced gets a new rowid and counts as both removed and added.
+// Queries wait while the changes are applied, and never see some of them only.
+// Returns the numbers of vectors added and removed.
+func (idx *MetadataIndex) Update(db *database.DB) (added, removed int, err error) {
+	idx.updateMu.Lock()
+	defer idx.updateMu.Unlock()
+
+	sum, err := metadataChecksum(db, idx.src, idx.dim)
+	if err != nil || sum == idx.checksum {
+		return 0, 0, err
+	}
+	rowids, err := queryRowids(db, idx.src.table, "dataset_id", idx.src.where)
+	if err != nil {
+		return 0, 0, err
+	}
+	current := make(map[int64]bool, len(rowids))
+	for _, rowid := range rowids {
+		current[rowid] = true
+	}
+	var removedIDs []int64
+	var addedIDs []interface{}
+
+	idx.mu.RLock()
+	empty := len(idx.idMap) == 0
+	for id := range idx.idMap {
+		if !current[id] {
+			removedIDs = append(removedIDs, id)
+		}
+	}
+	for id := range current {
+		if _, ok := idx.idMap[id]; !ok {
+			addedIDs = append(addedIDs, id)
+		}
+	}
+	idx.mu.RUnlock()
+
+	// If no rowid changed, vectors were rewritten in place, or rows of the
+	// table that are not indexed changed, which only a rebuild tells apart.
+	// An empty index is rebuilt so that it is trained on the added vectors.
+	if len(removedIDs) == 0 && len(addedIDs) == 0 || empty ||
+		len(removedIDs) > 0 && !idx.config.canRemove() {
+		return idx.rebuild(db, len(addedIDs), len(removedIDs))
 	}
-	if err := rows.Err(); err != nil {
+	addedVecs := &metadataVectors{dim: idx.dim}
+	for len(addedIDs) > 0 {
+		n := len(addedIDs)
+		if n > maxQueryParams {
+			n = maxQueryParams
+		}
+		err := addedVecs.query(db,
+			idx.src.query("rowid IN ("+queryParams(n)+")"), addedIDs[:n]...)
+		if err != nil {
+			return 0, 0, err
+		}
+		addedIDs = addedIDs[n:]
+	}
+	if err := idx.apply(removedIDs, addedVecs); err != nil {
+		return 0, 0, err
+	}
+	idx.checksum = sum
+	return len(addedVecs.ids), len(removedIDs), nil
+}
+
+// rebuild replaces the faiss index with one built from the table of the
+// vectors, for changes that cannot be applied incrementally. Returns the given
+// numbers of added and removed vectors.
+func (idx *MetadataIndex) rebuild(db *database.DB, added, removed int) (int, int, error) {
+	rebuilt, err := buildMetadataIndex(db, idx.src, idx.dim, idx.config)
+	if err != nil {
+		return 0, 0, err
+	}
+	idx.mu.Lock()
+	old := idx.idx
+	idx.idx, idx.idMap = rebuilt.idx, rebuilt.idMap
+	idx.mu.Unlock()
+
+	old.Delete()
+	idx.checksum = rebuilt.checksum
+	return added, removed, nil
+}
+
+// MetadataFieldIndexFile returns the name of the index file of the vectors of
+// a metadata field in the index directory.
+func MetadataFieldIndexFile(field string) string {
+	return "metadata_" + field + "_embedding.idx"
+}
+
+// WriteMetadataEmbeddingIndex writes the dim-dimensional metadata embedding
+// vectors to the file at path, to be loaded by LoadMetadataEmbeddingIndex.
+//
+// go-faiss cannot serialize indexes, so the file holds the vectors as stored
+// in the database, and approximate indexes are trained again on load.
+func WriteMetadataEmbeddingIndex(db *database.DB, path string, dim int) error {
+	return writeMetadataIndex(db, metadataSource, path, dim)
+}
+
+// WriteMetadataFieldIndex writes the dim-dimensional embedding vectors of a
+// metadata field to the file at path, to be loaded by LoadMetadataFieldIndex.
+func WriteMetadataFieldIndex(db *database.DB, field, path string, dim int) error {
+	src, err := fieldVectors(field)
+	if err != nil {
+		return err
+	}
+	return writeMetadataIndex(db, src, path, dim)
+}
+
+func writeMetadataIndex(db *database.DB, src *vectorSource, path string, dim int) error {
+	// The checksum is computed first so that changes made while the file is
+	// written make it stale.
+	sum, err := metadataChecksum(db, src, dim)
+	if err != nil {
+		return err
+	}
+	w, err := createIndexFile(path, sum, dim*4)
+	if err != nil {
+		return err
+	}
+	if err := func() error {
+		rows, err := db.Query(src.query(""))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var rowid int64
+			var datasetID string
+			var emb []byte
+
+			if err := rows.Scan(&rowid, &datasetID, &emb); err != nil {
+				return err
+			}
+			if len(emb) != dim*4 {
+				return fmt.Errorf("metadata vector of dataset %v has dimension %d, want %d",
+					datasetID, len(emb)/4, dim)
+			}
+			if err := w.Add(datasetID, rowid, 0, emb); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	}(); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Close()
+}
+
+// LoadMetadataEmbeddingIndex builds a MetadataIndex of dim-dimensional vectors
+// with the faiss index selected by cfg from the file at path.
+// Returns ErrStaleIndex if metadata_vectors has changed since the file was
+// written, or if it was written with another dimension.
+func LoadMetadataEmbeddingIndex(db *database.DB, path string, dim int, cfg *FaissConfig) (*MetadataIndex, error) {
+	return loadMetadataIndex(db, metadataSource, path, dim, cfg)
+}
+
+// LoadMetadataFieldIndex builds a MetadataIndex of the dim-dimensional vectors
+// of a metadata field with the faiss index selected by cfg from the file at
+// path, as LoadMetadataEmbeddingIndex.
+func LoadMetadataFieldIndex(db *database.DB, field, path string, dim int, cfg *FaissConfig) (*MetadataIndex, error) {
+	src, err := fieldVectors(field)
+	if err != nil {
 		return nil, err
 	}
-	if err := index.Add(vecs); err != nil {
+	return loadMetadataIndex(db, src, path, dim, cfg)
+}
+
+func loadMetadataIndex(db *database.DB, src *vectorSource, path string, dim int, cfg *FaissConfig) (*MetadataIndex, error) {
+	sum, err := metadataChecksum(db, src, dim)
+	if err != nil {
+		return nil, err
+	}
+	f, err := openIndexFile(path, sum)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	idx, err := newMetadataIndex(src, dim, cfg, sum, func() ([]float32, error) {
+		vecs := make([]float32, 0, len(f.Keys)*dim)
+		for i := range f.Keys {
+			vec, err := vec32.FromBytes(f.entry(i))
+			if err != nil {
+				return nil, err
+			}
+			vecs = append(vecs, vec...)
+		}
+		return vecs, nil
+	})
+	if err != nil {
 		return nil, err
 	}
+	// The vectors are decoded from the mapped file in batches to avoid
+	// holding a copy of all of them, unless they were used for training.
+	vecs := make([]float32, 0, loadBatchSize*dim)
+	start := 0
 
-	return &MetadataIndex{index, idMap}, nil
+	for i, key := range f.Keys {
+		vec, err := vec32.FromBytes(f.entry(i))
+		if err != nil {
+			idx.Delete()
+			return nil, err
+		}
+		vecs = append(vecs, vec...)
+		idx.idMap[f.Rowids[i]] = key
+
+		if len(vecs) == cap(vecs) || i == len(f.Keys)-1 {
+			if err := idx.idx.AddWithIDs(vecs, f.Rowids[start:i+1]); err != nil {
+				idx.Delete()
+				return nil, err
+			}
+			vecs = vecs[:0]
+			start = i + 1
+		}
+	}
+	return idx, nil
 }
 
 // Delete frees the memory associated with the index.
@@ -63,6 +452,18 @@ func (idx *MetadataIndex) Delete() {
 // Returns the dataset IDs of the (up to) k nearest neighbors and the
 // corresponding cosine similarity, sorted by similarity.
 func (idx *MetadataIndex) Query(vec []float32, k int64) ([]string, []float32, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return idx.query(vec, k)
+}
+
+// query must be called with idx.mu held. Faiss is not searched if the index is
+// empty or k is not positive.
+func (idx *MetadataIndex) query(vec []float32, k int64) ([]string, []float32, error) {
+	if k <= 0 || idx.idx.Ntotal() == 0 {
+		return nil, nil, nil
+	}
 	dist, ids, err := idx.idx.Search(vec, k)
 	if err != nil {
 		return nil, nil, err
@@ -77,3 +478,45 @@ func (idx *MetadataIndex) Query(vec []float32, k int64) ([]string, []float32, er
 	}
 	return datasets, dist[:len(datasets)], nil
 }
+
+// QueryFiltered queries the index with vec, considering only the datasets for
+// which allow returns true.
+//
+// Returns the dataset IDs of the (up to) k nearest allowed neighbors and the
+// corresponding cosine similarity, sorted by similarity.
+func (idx *MetadataIndex) QueryFiltered(vec []float32, k int64, allow func(datasetID string) bool) ([]string, []float32, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	ntotal := idx.idx.Ntotal()
+	if ntotal == 0 {
+		return nil, nil, nil
+	}
+
+	// Search increasingly many neighbors until k of them are allowed or the
+	// whole index has been searched.
+	for n := k; ; n *= 4 {
+		if n > ntotal {
+			n = ntotal
+		}
+		datasets, dist, err := idx.query(vec, n)
+		if err != nil {
+			return nil, nil, err
+		}
+		var allowed []string
+		var sims []float32
+
+		for i, id := range datasets {
+			if int64(len(allowed)) == k {
+				break
+			}
+			if allow(id) {
+				allowed = append(allowed, id)
+				sims = append(sims, dist[i])
+			}
+		}
+		if int64(len(allowed)) == k || n == ntotal {
+			return allowed, sims, nil
+		}
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+// +build !windows
+
+package index
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the file at path into memory read-only.
+func mmapFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() == 0 {
+		return nil, errCorruptFile
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmap unmaps memory mapped by mmapFile.
+func munmap(b []byte) error {
+	return syscall.Munmap(b)
+}
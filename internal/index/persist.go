@@ -0,0 +1,291 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+// Names of the index files in the index directory.
+const (
+	MetadataIndexFile    = "metadata_embedding.idx"
+	JoinabilityIndexFile = "joinability.idx"
+	ColumnPairIndexFile  = "column_pair.idx"
+)
+
+// Version of the index file format, part of the checksums.
+const fileVersion = 4
+
+// Identifies index files.
+var fileMagic = []byte("ODLINDEX")
+
+// ErrStaleIndex is returned when loading an index file that was not built from
+// the current contents of the database.
+var ErrStaleIndex = errors.New("index: index file is out of date")
+
+var errCorruptFile = errors.New("index: corrupt index file")
+
+// An index file is made of the magic number, the data section, the
+// gob-encoded fileHeader and the length of the header as a big-endian uint64.
+// The data section holds the entries of the index, each made of Width bytes
+// as stored in the database: a big-endian embedding vector or minhash
+// signature.
+type fileHeader struct {
+	// Checksum of the source table and build parameters.
+	Checksum string
+	// Keys of the entries.
+	Keys []string
+	// Rowids of the entries in their table, used to find the entries changed
+	// since the file was written.
+	Rowids []int64
+	// Distinct counts of the entries of LSH Ensemble indexes.
+	Sizes []int
+	// Size of an entry in bytes.
+	Width int
+}
+
+// indexFile is a memory-mapped index file.
+type indexFile struct {
+	*fileHeader
+	data []byte
+	mmap []byte
+}
+
+// entry returns the data of the i-th entry.
+func (f *indexFile) entry(i int) []byte {
+	return f.data[i*f.Width : (i+1)*f.Width]
+}
+
+// Close unmaps the file.
+func (f *indexFile) Close() error {
+	return munmap(f.mmap)
+}
+
+// tableChecksum returns a checksum of the rows of table that satisfy where,
+// which may be empty, and of the parameters of the index built from it.
+//
+// If the table has a row in table_versions, kept by the triggers created with
+// it, the checksum covers the version of the table and the number and largest
+// rowid of the rows, which SQLite finds without reading the rows. Otherwise it
+// covers the rowids and the contents of the given columns of the rows, which
+// are read in full. Either way it changes when rows are inserted, replaced or
+// deleted, and when they are rewritten in place, even with values of the same
+// length.
+// Returns "" if the table does not exist.
+func tableChecksum(db *database.DB, table, where string, columns []string, params ...int) (string, error) {
+	exists, err := db.HasTable(table)
+	if err != nil || !exists {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintln(h, fileVersion, table, where, params)
+
+	generation, version, err := tableVersion(db, table)
+	if err == sql.ErrNoRows {
+		err = hashRows(h, db, table, where, columns)
+	} else if err == nil {
+		err = hashRowCount(h, db, table, where, generation, version)
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// tableVersion returns the generation and version of table in table_versions.
+// Returns sql.ErrNoRows if the table has none.
+func tableVersion(db *database.DB, table string) (generation string, version int64, err error) {
+	exists, err := db.HasTable("table_versions")
+	if err != nil {
+		return "", 0, err
+	}
+	if !exists {
+		return "", 0, sql.ErrNoRows
+	}
+	err = db.QueryRow(`
+	SELECT generation, version FROM table_versions WHERE name = ?`,
+		table).Scan(&generation, &version)
+	return generation, version, err
+}
+
+// hashRowCount writes the version of table and the number and largest rowid of
+// the rows that satisfy where to h.
+func hashRowCount(h io.Writer, db *database.DB, table, where, generation string, version int64) error {
+	query := "SELECT COUNT(*), COALESCE(MAX(rowid), 0) FROM " + table
+	if where != "" {
+		query += " WHERE " + where
+	}
+	var count, maxRowid int64
+	if err := db.QueryRow(query).Scan(&count, &maxRowid); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(h, generation, version, count, maxRowid)
+	return err
+}
+
+// hashRows writes the rowids and the contents of columns of the rows of table
+// that satisfy where to h.
+func hashRows(h io.Writer, db *database.DB, table, where string, columns []string) error {
+	query := fmt.Sprintf("SELECT rowid, %s FROM %s", strings.Join(columns, ", "), table)
+	if where != "" {
+		query += " WHERE " + where
+	}
+	rows, err := db.Query(query + " ORDER BY rowid")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var rowid int64
+	values := make([]sql.RawBytes, len(columns))
+	dest := []interface{}{&rowid}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+	var buf [8]byte
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint64(buf[:], uint64(rowid))
+		h.Write(buf[:])
+		// Values are prefixed with their length so that they cannot run
+		// into each other.
+		for _, v := range values {
+			binary.BigEndian.PutUint64(buf[:], uint64(len(v)))
+			h.Write(buf[:])
+			h.Write(v)
+		}
+	}
+	return rows.Err()
+}
+
+// indexFileWriter writes an index file.
+type indexFileWriter struct {
+	f      *os.File
+	w      *bufio.Writer
+	path   string
+	header *fileHeader
+}
+
+// createIndexFile creates a temporary file next to path for an index file with
+// entries of width bytes. The file replaces the file at path on Close.
+func createIndexFile(path, checksum string, width int) (*indexFileWriter, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	if _, err := w.Write(fileMagic); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &indexFileWriter{
+		f:      f,
+		w:      w,
+		path:   path,
+		header: &fileHeader{Checksum: checksum, Width: width},
+	}, nil
+}
+
+// Add appends an entry to the file.
+func (iw *indexFileWriter) Add(key string, rowid int64, size int, data []byte) error {
+	if len(data) != iw.header.Width {
+		return fmt.Errorf("index: %s has %d bytes, want %d",
+			key, len(data), iw.header.Width)
+	}
+	iw.header.Keys = append(iw.header.Keys, key)
+	iw.header.Rowids = append(iw.header.Rowids, rowid)
+	iw.header.Sizes = append(iw.header.Sizes, size)
+	_, err := iw.w.Write(data)
+	return err
+}
+
+// Close writes the header and moves the file to its path.
+func (iw *indexFileWriter) Close() error {
+	defer os.Remove(iw.f.Name())
+	defer iw.f.Close()
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(iw.header); err != nil {
+		return err
+	}
+	if err := binary.Write(&buf, binary.BigEndian, uint64(buf.Len())); err != nil {
+		return err
+	}
+	if _, err := buf.WriteTo(iw.w); err != nil {
+		return err
+	}
+	if err := iw.w.Flush(); err != nil {
+		return err
+	}
+	if err := iw.f.Sync(); err != nil {
+		return err
+	}
+	if err := iw.f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(iw.f.Name(), iw.path)
+}
+
+// Abort removes the temporary file.
+func (iw *indexFileWriter) Abort() {
+	iw.f.Close()
+	os.Remove(iw.f.Name())
+}
+
+// openIndexFile memory-maps the index file at path.
+// Returns ErrStaleIndex if the checksum of the file is not checksum.
+func openIndexFile(path, checksum string) (*indexFile, error) {
+	m, err := mmapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseIndexFile(m, checksum)
+	if err != nil {
+		munmap(m)
+		return nil, err
+	}
+	return f, nil
+}
+
+func parseIndexFile(m []byte, checksum string) (*indexFile, error) {
+	if len(m) < len(fileMagic)+8 || !bytes.Equal(m[:len(fileMagic)], fileMagic) {
+		return nil, errCorruptFile
+	}
+	end := len(m) - 8
+	n := binary.BigEndian.Uint64(m[end:])
+	if n > uint64(end-len(fileMagic)) {
+		return nil, errCorruptFile
+	}
+	start := end - int(n)
+
+	header := new(fileHeader)
+	if err := gob.NewDecoder(bytes.NewReader(m[start:end])).Decode(header); err != nil {
+		return nil, errCorruptFile
+	}
+	if header.Checksum != checksum {
+		return nil, ErrStaleIndex
+	}
+	data := m[len(fileMagic):start]
+	if len(header.Rowids) != len(header.Keys) ||
+		len(header.Sizes) != len(header.Keys) ||
+		len(data) != len(header.Keys)*header.Width {
+		return nil, errCorruptFile
+	}
+	return &indexFile{fileHeader: header, data: data, mmap: m}, nil
+}
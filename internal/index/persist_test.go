@@ -0,0 +1,263 @@
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/ekzhu/lshensemble"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func minhash(values ...int) []uint64 {
+	mh := lshensemble.NewMinhash(1, mhSize)
+	for _, v := range values {
+		mh.Push([]byte(strconv.Itoa(v)))
+	}
+	return mh.Signature()
+}
+
+func newColumnSketchDB(t *testing.T) *database.DB {
+	db, err := database.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+	CREATE TABLE column_sketches (
+		column_id TEXT NOT NULL PRIMARY KEY,
+		distinct_count INT NOT NULL,
+		minhash BLOB NOT NULL
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// addTableVersion creates the table_versions row and triggers of the
+// column_sketches table, as in sql/create_column_sketches_table.sql.
+func addTableVersion(t *testing.T, db *database.DB) {
+	_, err := db.Exec(`
+	CREATE TABLE table_versions (
+		name TEXT NOT NULL PRIMARY KEY,
+		generation TEXT NOT NULL,
+		version INTEGER NOT NULL
+	);
+	INSERT INTO table_versions VALUES ('column_sketches', hex(randomblob(16)), 0);
+	CREATE TRIGGER column_sketches_update AFTER UPDATE ON column_sketches
+	BEGIN
+		UPDATE table_versions SET version = version + 1 WHERE name = 'column_sketches';
+	END;
+	CREATE TRIGGER column_sketches_delete AFTER DELETE ON column_sketches
+	BEGIN
+		UPDATE table_versions SET version = version + 1 WHERE name = 'column_sketches';
+	END;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func insertColumnSketch(t *testing.T, db *database.DB, id string, values ...int) {
+	_, err := db.Exec(`INSERT INTO column_sketches VALUES (?, ?, ?)`,
+		id, len(values), lshensemble.SigToBytes(minhash(values...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func queryAll(idx *LshIndex, sig []uint64, size int) []string {
+	done := make(chan struct{})
+	defer close(done)
+
+	var keys []string
+	for key := range idx.Query(sig, size, 0.5, done) {
+		keys = append(keys, key.(string))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestJoinabilityIndexFile(t *testing.T) {
+	db := newColumnSketchDB(t)
+	defer db.Close()
+
+	var values []int
+	for i := 0; i < 20; i++ {
+		values = append(values, i*i)
+		insertColumnSketch(t, db, "a-"+strconv.Itoa(i), values...)
+	}
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, JoinabilityIndexFile)
+
+	if err := WriteJoinabilityIndex(db, path); err != nil {
+		t.Fatal(err)
+	}
+	built, err := BuildJoinabilityIndex(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadJoinabilityIndex(db, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.base.Partitions, built.base.Partitions) {
+		t.Errorf("partitions = %v, want %v", loaded.base.Partitions, built.base.Partitions)
+	}
+	sig := minhash(values[:10]...)
+	got := queryAll(loaded, sig, 10)
+	want := queryAll(built, sig, 10)
+	if len(got) == 0 || !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded index returned %v, want %v", got, want)
+	}
+
+	insertColumnSketch(t, db, "b-0", 1, 2, 3)
+	if _, err := LoadJoinabilityIndex(db, path); err != ErrStaleIndex {
+		t.Errorf("after insert, err = %v, want ErrStaleIndex", err)
+	}
+	if _, err := db.Exec(`DELETE FROM column_sketches WHERE column_id = 'b-0'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`DELETE FROM column_sketches WHERE column_id = 'a-0'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadJoinabilityIndex(db, path); err != ErrStaleIndex {
+		t.Errorf("after delete, err = %v, want ErrStaleIndex", err)
+	}
+}
+
+// Rewriting a sketch in place keeps its rowid and length, but must make index
+// files stale and be applied by Update.
+func TestSketchRewrittenInPlace(t *testing.T) {
+	db := newColumnSketchDB(t)
+	defer db.Close()
+
+	var values []int
+	for i := 0; i < 20; i++ {
+		values = append(values, i)
+		insertColumnSketch(t, db, "a-"+strconv.Itoa(i), values...)
+	}
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, JoinabilityIndexFile)
+
+	if err := WriteJoinabilityIndex(db, path); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := BuildJoinabilityIndex(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := minhash(values[:10]...)
+	if keys := queryAll(idx, sig, 10); !contains(keys, "a-19") {
+		t.Fatalf("query returned %v, want a-19", keys)
+	}
+
+	_, err = db.Exec(`UPDATE column_sketches SET minhash = ? WHERE column_id = 'a-19'`,
+		lshensemble.SigToBytes(minhash(100, 101, 102)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadJoinabilityIndex(db, path); err != ErrStaleIndex {
+		t.Errorf("after rewrite, err = %v, want ErrStaleIndex", err)
+	}
+	if _, _, err := idx.Update(db); err != nil {
+		t.Fatal(err)
+	}
+	if keys := queryAll(idx, sig, 10); contains(keys, "a-19") {
+		t.Errorf("after rewrite and update, query returned %v, want no a-19", keys)
+	}
+}
+
+func TestIndexOfMissingTable(t *testing.T) {
+	db, err := database.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	idx, err := buildLshIndex(db, columnSketches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added, removed, err := idx.Update(db); err != nil || added != 0 || removed != 0 {
+		t.Errorf("Update() = %v, %v, %v; want no changes", added, removed, err)
+	}
+	_, err = db.Exec(`
+	CREATE TABLE column_sketches (
+		column_id TEXT NOT NULL PRIMARY KEY,
+		distinct_count INT NOT NULL,
+		minhash BLOB NOT NULL
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insertColumnSketch(t, db, "a-0", 1, 2, 3)
+	if added, _, err := idx.Update(db); err != nil || added != 1 {
+		t.Fatalf("Update() added %v, %v; want 1", added, err)
+	}
+	if keys := queryAll(idx, minhash(1, 2, 3), 3); !contains(keys, "a-0") {
+		t.Errorf("query found %v, want a-0", keys)
+	}
+}
+
+func TestVersionedTableChecksum(t *testing.T) {
+	db := newColumnSketchDB(t)
+	defer db.Close()
+	addTableVersion(t, db)
+
+	insertColumnSketch(t, db, "a-0", 1, 2, 3)
+	insertColumnSketch(t, db, "a-1", 4, 5, 6)
+	sums := make(map[string]string)
+	for _, change := range []struct {
+		name, query string
+	}{
+		{"unchanged", ""},
+		{"insert", `INSERT INTO column_sketches VALUES ('a-2', 1, x'00')`},
+		// The rowid of the deleted row is reused by the inserted row.
+		{"replace", `DELETE FROM column_sketches WHERE column_id = 'a-2';
+		INSERT INTO column_sketches VALUES ('a-3', 1, x'00')`},
+		{"rewrite", `UPDATE column_sketches SET minhash = x'01' WHERE column_id = 'a-3'`},
+		{"delete", `DELETE FROM column_sketches WHERE column_id = 'a-0'`},
+	} {
+		if change.query != "" {
+			if _, err := db.Exec(change.query); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sum, err := lshChecksum(db, columnSketches)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prev, ok := sums[sum]; ok {
+			t.Errorf("checksum after %v equals checksum after %v", change.name, prev)
+		}
+		sums[sum] = change.name
+	}
+	if sum, err := lshChecksum(db, columnSketches); err != nil || sums[sum] != "delete" {
+		t.Errorf("checksum of unchanged table changed: %v", err)
+	}
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
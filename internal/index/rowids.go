@@ -0,0 +1,41 @@
+package index
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+// Maximum number of parameters of a query, below the default SQLite limit.
+const maxQueryParams = 999
+
+// queryParams returns n comma-separated query parameters.
+func queryParams(n int) string {
+	return "?" + strings.Repeat(", ?", n-1)
+}
+
+// queryRowids returns a map of the keys of the rows of table that satisfy
+// where, which may be empty, to their rowids.
+func queryRowids(db *database.DB, table, key, where string) (map[string]int64, error) {
+	query := fmt.Sprintf(`SELECT rowid, %s FROM %s`, key, table)
+	if where != "" {
+		query += " WHERE " + where
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rowids := make(map[string]int64)
+	for rows.Next() {
+		var rowid int64
+		var k string
+		if err := rows.Scan(&rowid, &k); err != nil {
+			return nil, err
+		}
+		rowids[k] = rowid
+	}
+	return rowids, rows.Err()
+}
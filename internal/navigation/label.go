@@ -5,14 +5,15 @@ import (
 
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
 	indexpkg "github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
-	"github.com/ekzhu/go-fasttext"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
 )
 
-func (O *TableGraph) labelNodes(db *database.DB, ft *fasttext.FastText) error {
-	idx, err := indexpkg.BuildCategoryEmbeddingIndex(db, ft)
+func (O *TableGraph) labelNodes(db *database.DB, emb wordemb.Embedder, cfg *indexpkg.FaissConfig) error {
+	idx, err := indexpkg.BuildCategoryEmbeddingIndex(db, emb, cfg)
 	if err != nil {
 		return err
 	}
+	defer idx.Delete()
 
 	usedLabels := make(map[string]bool)
 
@@ -24,17 +25,18 @@ func (O *TableGraph) labelNodes(db *database.DB, ft *fasttext.FastText) error {
 			return err
 		}
 
+		// Approximate indexes may return fewer than 20 categories.
 		var i int
-		for i = 0; i < 20; i++ {
+		for i = 0; i < len(names); i++ {
 			if !usedLabels[strings.ToLower(names[i])] {
 				break
 			}
 		}
 		var label string
-		if i == 20 {
-			label = names[0]
-		} else {
+		if i < len(names) {
 			label = names[i]
+		} else if len(names) > 0 {
+			label = names[0]
 		}
 
 		usedLabels[strings.ToLower(label)] = true
@@ -2,14 +2,16 @@ package navigation
 
 import (
 	"container/heap"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"math"
 
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	indexpkg "github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
 	"github.com/DataIntelligenceCrew/go-faiss"
-	"github.com/ekzhu/go-fasttext"
 	"gonum.org/v1/gonum/blas/blas32"
 	"gonum.org/v1/gonum/graph"
 	"gonum.org/v1/gonum/graph/encoding"
@@ -24,10 +26,10 @@ type Config struct {
 	TerminationThreshold float64 // The threshold below which the learning algorithm stops
 	TerminationWindow    int     // The number of prior iterations to account for in terminating
 	MaxIters             int     // The node reachability below which we choose to delete a parent instead of adding a parent
+	// Faiss index of the category embeddings used to label nodes.
+	LabelIndex indexpkg.FaissConfig
 }
 
-const embeddingDim = 300
-
 // Node is a node in the organization graph.
 type Node struct {
 	id                 int64
@@ -36,6 +38,7 @@ type Node struct {
 	name               string
 	dataset            string
 	hasDatasetChild    bool
+	url                string // Link target in the DOT encoding
 }
 
 func (n *Node) Vector() []float32 { return n.vector }
@@ -55,12 +58,12 @@ func newDatasetNode(id int64, vector []float32, datasetID string) *Node {
 }
 
 func newMergedNode(id int64, a, b *Node) *Node {
-	vec := make([]float32, embeddingDim)
+	vec := make([]float32, len(a.vector))
 	vec32.Add(vec, a.vector)
 	vec32.Add(vec, b.vector)
 	vec32.Scale(vec, 0.5)
 	vec32.Normalize(vec)
-	return &Node{id, 0, vec, "", "", false}
+	return &Node{id: id, vector: vec}
 }
 
 // TableGraph the custom graph structure for an organization
@@ -70,10 +73,11 @@ type TableGraph struct {
 	root      graph.Node
 	rootPaths path.Shortest
 	leafNodes []*Node
+	dim       int // Dimension of the node vectors
 }
 
-func newGraph(cfg *Config) *TableGraph {
-	return &TableGraph{simple.NewDirectedGraph(), cfg, nil, path.Shortest{}, make([]*Node, 0)}
+func newGraph(cfg *Config, dim int) *TableGraph {
+	return &TableGraph{simple.NewDirectedGraph(), cfg, nil, path.Shortest{}, make([]*Node, 0), dim}
 }
 
 // addDatasetNodes creates nodes for the datasets and adds them to the graph.
@@ -98,6 +102,10 @@ func (O *TableGraph) addDatasetNodes(db *database.DB, ids []string) error {
 		if err != nil {
 			return err
 		}
+		if len(vec) != O.dim {
+			return fmt.Errorf("metadata vector of dataset %v has dimension %d, want %d",
+				datasetID, len(vec), O.dim)
+		}
 		id := O.NewNode().ID()
 		var n = newDatasetNode(id, vec, datasetID)
 		O.AddNode(n)
@@ -138,7 +146,7 @@ type nodePair struct {
 }
 
 func buildIndex(g *TableGraph) (*index, error) {
-	idx, err := faiss.IndexFactory(embeddingDim, "IDMap,Flat", faiss.MetricInnerProduct)
+	idx, err := faiss.IndexFactory(g.dim, "IDMap,Flat", faiss.MetricInnerProduct)
 	if err != nil {
 		return nil, err
 	}
@@ -241,16 +249,27 @@ func (pq *priorityQueue) Pop() interface{} {
 	return item
 }
 
-func BuildOrganization(db *database.DB, ft *fasttext.FastText, cfg *Config, ids []string) (*TableGraph, error) {
-	g, err := BuildInitialOrg(db, cfg, ids)
+// ProgressFunc is called by BuildOrganization after each operation applied to
+// the organization with the number of operations so far and the organization
+// effectiveness.
+type ProgressFunc func(iterations int, effectiveness float64)
+
+// BuildOrganization builds an organization of the given datasets, whose
+// metadata vectors were created by emb. Nodes are labeled with the categories
+// whose embeddings are nearest to their vectors.
+//
+// The optimization stops and ctx.Err() is returned if ctx is canceled.
+// progress may be nil.
+func BuildOrganization(ctx context.Context, db *database.DB, emb wordemb.Embedder, cfg *Config, ids []string, progress ProgressFunc) (*TableGraph, error) {
+	g, err := BuildInitialOrg(db, cfg, emb.Dim(), ids)
 	if err != nil {
 		return nil, err
 	}
-	g, err = g.organize()
+	g, err = g.organize(ctx, progress)
 	if err != nil {
 		return nil, err
 	}
-	if err := g.labelNodes(db, ft); err != nil {
+	if err := g.labelNodes(db, emb, &cfg.LabelIndex); err != nil {
 		return nil, err
 	}
 	return g, nil
@@ -259,10 +278,11 @@ func BuildOrganization(db *database.DB, ft *fasttext.FastText, cfg *Config, ids
 // buildInitialOrg builds the initial organization of the navigation graph.
 //
 // The initial organization is a binary tree created by joining the most similar
-// pairs of nodes under a parent node.
-func BuildInitialOrg(db *database.DB, cfg *Config, ids []string) (*TableGraph, error) {
+// pairs of nodes under a parent node. dim is the dimension of the metadata
+// vectors of the datasets.
+func BuildInitialOrg(db *database.DB, cfg *Config, dim int, ids []string) (*TableGraph, error) {
 	// Create nodes for all datasets and add them to graph.
-	g := newGraph(cfg)
+	g := newGraph(cfg, dim)
 	if err := g.addDatasetNodes(db, ids); err != nil {
 		return nil, err
 	}
@@ -462,7 +482,7 @@ func (n *Node) copy() *Node {
 	out = new(Node)
 	out.id = n.id
 	out.cachedReachibility = n.cachedReachibility
-	out.vector = make([]float32, embeddingDim)
+	out.vector = make([]float32, len(n.vector))
 	copy(out.vector, n.vector)
 	out.name = n.name
 	out.dataset = n.dataset
@@ -473,7 +493,7 @@ func (n *Node) copy() *Node {
 // Wrapper around GoNum's implementation
 func (O *TableGraph) CopyOrganization() *TableGraph {
 	// FIXME: root is wrong
-	out := &TableGraph{simple.NewDirectedGraph(), O.config, O.root.(*Node).copy(), O.rootPaths, make([]*Node, 0)}
+	out := &TableGraph{simple.NewDirectedGraph(), O.config, O.root.(*Node).copy(), O.rootPaths, make([]*Node, 0), O.dim}
 
 	// Deep copy nodes
 	for it := O.Nodes(); it.Next(); {
@@ -524,7 +544,7 @@ func (O *TableGraph) eliminateNode(s graph.Node) {
  *
  */
 func (O *TableGraph) update_vector(s *Node) []float32 {
-	total := make([]float32, embeddingDim)
+	total := make([]float32, O.dim)
 	var n int = 0
 	for it := O.getChildren(s); it.Next(); {
 		if O.getChildren(it.Node()).Len() != 0 {
@@ -752,12 +772,16 @@ func (O *TableGraph) buildPriorityQueue() []ReachabilityPriorityQueue {
 }
 
 // Non determinism comes from the fact that the cached reachability of the nodes is not updated frequently enough, leading to the priority queue to be built in a non-deterministic fashion.
-func (O *TableGraph) organize() (*TableGraph, error) {
+func (O *TableGraph) organize(ctx context.Context, progress ProgressFunc) (*TableGraph, error) {
 	t := &terminationMonitor{make([]float64, O.config.TerminationWindow), make([]int, O.config.TerminationWindow), 0, 0}
 	// idx, err := buildIndex(O)
 	// if err != nil {
 	// 	return nil, err
 	// }
+	if progress == nil {
+		progress = func(int, float64) {}
+	}
+	iterations := 0
 
 	it := O.Nodes()
 
@@ -772,9 +796,14 @@ func (O *TableGraph) organize() (*TableGraph, error) {
 		for level := range pq {
 			lvl := level
 			for pq[lvl].HasNext() {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
 				s := pq[lvl].Pop().(*Node)
 				var Op = O.applyDelOperation(s, lvl)
 				O, p = O.accept(Op)
+				iterations++
+				progress(iterations, p)
 			}
 		}
 
@@ -786,10 +815,15 @@ func (O *TableGraph) organize() (*TableGraph, error) {
 		for level := range pq {
 			lvl := level //len(pq) - level - 1 // For reverse order
 			for pq[lvl].HasNext() {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
 				s := pq[lvl].Pop().(*Node)
 				var Op = O.chooseApplyOperation(s, lvl)
 				O, p = O.accept(Op)
 				t.updateWindow(p, int(s.ID()))
+				iterations++
+				progress(iterations, p)
 			}
 			pq = O.buildPriorityQueue()
 			if O.terminate(t, p) {
@@ -822,16 +856,19 @@ func (n *Node) Attributes() []encoding.Attribute {
 	}
 	attrs := []encoding.Attribute{
 		{Key: "label", Value: label},
-		{Key: "URL", Value: fmt.Sprint("/navigation/", n.id)},
+		{Key: "URL", Value: n.url},
 	}
 	return attrs
 }
 
-func (O *TableGraph) MarshalDOT() ([]byte, error) {
+// MarshalDOT encodes the organization without its dataset nodes in the DOT
+// format. Nodes link to nodeURL followed by the node ID.
+func (O *TableGraph) MarshalDOT(nodeURL string) ([]byte, error) {
 	g := O.CopyOrganization()
 	var leafNodes []int64
 	for it := g.Nodes(); it.Next(); {
 		id := it.Node().(*Node).ID()
+		it.Node().(*Node).url = fmt.Sprint(nodeURL, id)
 		if g.From(id).Len() == 0 {
 			for jt := g.To(id); jt.Next(); {
 				jt.Node().(*Node).hasDatasetChild = true
@@ -846,7 +883,7 @@ func (O *TableGraph) MarshalDOT() ([]byte, error) {
 }
 
 func (O *TableGraph) ToVisualizer(path string) {
-	data, err := O.MarshalDOT()
+	data, err := O.MarshalDOT("/navigation/")
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -5,18 +5,18 @@ import (
 )
 
 type IDNamePair struct {
-	ID      int64
-	Name    string
-	Dataset string
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Dataset string `json:"dataset_id,omitempty"`
 }
 
 // ServeableNode a data structure containing node information for the frontend
 type ServeableNode struct {
-	ID        int64
-	NodeName  string
-	Dataset   string
-	ParentIDs []*IDNamePair
-	ChildIDs  []*IDNamePair
+	ID        int64         `json:"id"`
+	NodeName  string        `json:"name"`
+	Dataset   string        `json:"dataset_id,omitempty"`
+	ParentIDs []*IDNamePair `json:"parents"`
+	ChildIDs  []*IDNamePair `json:"children"`
 }
 
 // ToServeableNode converts a node in the organization into a node that is serveable
@@ -13,7 +13,7 @@ func allocateGraph(t testing.TB) (*TableGraph, *database.DB) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	g, err := BuildInitialOrg(db, &Config{Gamma: 20, TerminationThreshold: 1.25e-15, TerminationWindow: 500, MaxIters: 1e6}, make([]string, 0))
+	g, err := BuildInitialOrg(db, &Config{Gamma: 20, TerminationThreshold: 1.25e-15, TerminationWindow: 500, MaxIters: 1e6}, 300, make([]string, 0))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -72,7 +72,7 @@ func BenchmarkOrganize(b *testing.B) {
 	b.Logf("Initial Organization Effectiveness: %v", g.getOrganizationEffectiveness())
 	g.ToVisualizer("pre_optimized.dot")
 	b.ResetTimer()
-	gprime, err := g.organize()
+	gprime, err := g.organize(context.Background(), nil)
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -0,0 +1,106 @@
+package navigation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+	"gonum.org/v1/gonum/graph/path"
+)
+
+// serializedGraph is the JSON encoding of a TableGraph.
+type serializedGraph struct {
+	Config *Config           `json:"config"`
+	Dim    int               `json:"dim"`
+	Root   int64             `json:"root"`
+	Nodes  []*serializedNode `json:"nodes"`
+	// Pairs of parent and child node IDs.
+	Edges [][2]int64 `json:"edges"`
+}
+
+type serializedNode struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Dataset string `json:"dataset_id,omitempty"`
+	// Big-endian float32 vector as encoded by vec32.Bytes.
+	Vector []byte `json:"vector"`
+}
+
+// MarshalJSON encodes the organization with its configuration, nodes and
+// edges.
+func (O *TableGraph) MarshalJSON() ([]byte, error) {
+	g := serializedGraph{Config: O.config, Dim: O.dim, Root: O.root.ID()}
+
+	for it := O.Nodes(); it.Next(); {
+		n := it.Node().(*Node)
+		g.Nodes = append(g.Nodes, &serializedNode{
+			ID:      n.id,
+			Name:    n.name,
+			Dataset: n.dataset,
+			Vector:  vec32.Bytes(n.vector),
+		})
+	}
+	for it := O.Edges(); it.Next(); {
+		e := it.Edge()
+		g.Edges = append(g.Edges, [2]int64{e.From().ID(), e.To().ID()})
+	}
+	return json.Marshal(&g)
+}
+
+// UnmarshalJSON decodes an organization encoded by MarshalJSON.
+func (O *TableGraph) UnmarshalJSON(data []byte) error {
+	var g serializedGraph
+	if err := json.Unmarshal(data, &g); err != nil {
+		return err
+	}
+	if g.Config == nil {
+		return errors.New("organization has no config")
+	}
+	if g.Dim <= 0 {
+		return errors.New("organization has no vector dimension")
+	}
+	*O = *newGraph(g.Config, g.Dim)
+
+	for _, n := range g.Nodes {
+		vec, err := vec32.FromBytes(n.Vector)
+		if err != nil {
+			return err
+		}
+		if len(vec) != g.Dim {
+			return fmt.Errorf("node %v: vector has dimension %d, want %d", n.ID, len(vec), g.Dim)
+		}
+		O.AddNode(&Node{id: n.ID, vector: vec, name: n.Name, dataset: n.Dataset})
+	}
+	for _, e := range g.Edges {
+		from, to := O.Node(e[0]), O.Node(e[1])
+		if from == nil || to == nil {
+			return fmt.Errorf("edge %v: no such node", e)
+		}
+		O.SetEdge(O.NewEdge(from, to))
+	}
+	if O.root = O.Node(g.Root); O.root == nil {
+		return fmt.Errorf("root %v: no such node", g.Root)
+	}
+	for it := O.Nodes(); it.Next(); {
+		if O.isLeafNode(it.Node()) {
+			O.leafNodes = append(O.leafNodes, it.Node().(*Node))
+		}
+	}
+	O.rootPaths = path.DijkstraFrom(O.root, O.DirectedGraph)
+	return nil
+}
+
+// UnmarshalTableGraph decodes an organization encoded by MarshalJSON.
+func UnmarshalTableGraph(data []byte) (*TableGraph, error) {
+	var O TableGraph
+	if err := O.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return &O, nil
+}
+
+// DatasetCount returns the number of datasets in the organization.
+func (O *TableGraph) DatasetCount() int {
+	return len(O.leafNodes)
+}
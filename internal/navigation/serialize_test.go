@@ -0,0 +1,63 @@
+package navigation
+
+import (
+	"reflect"
+	"testing"
+
+	indexpkg "github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	g := newGraph(&Config{
+		Gamma:             20,
+		TerminationWindow: 10,
+		MaxIters:          100,
+		LabelIndex: indexpkg.FaissConfig{
+			Description:  "HNSW32",
+			SearchParams: map[string]float64{"efSearch": 64},
+		},
+	}, 50)
+	va, vb := make([]float32, 50), make([]float32, 50)
+	va[0], vb[1] = 1, 1
+	a := newDatasetNode(g.NewNode().ID(), va, "aaaa-aaaa")
+	g.AddNode(a)
+	b := newDatasetNode(g.NewNode().ID(), vb, "bbbb-bbbb")
+	g.AddNode(b)
+	g.leafNodes = []*Node{a, b}
+	g.root = g.addMergedNode(a, b)
+	g.SetRootName("root")
+
+	data, err := g.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := UnmarshalTableGraph(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(h.config, g.config) {
+		t.Errorf("config = %+v, want %+v", *h.config, *g.config)
+	}
+	if h.dim != g.dim {
+		t.Errorf("dim = %v, want %v", h.dim, g.dim)
+	}
+	if h.root.ID() != g.root.ID() || h.root.(*Node).name != "root" {
+		t.Errorf("root = %+v, want %+v", h.root, g.root)
+	}
+	if h.DatasetCount() != 2 {
+		t.Errorf("DatasetCount() = %v, want 2", h.DatasetCount())
+	}
+	for _, n := range []*Node{a, b} {
+		m, ok := h.Node(n.id).(*Node)
+		if !ok || m.dataset != n.dataset || m.vector[0] != n.vector[0] {
+			t.Errorf("node %v = %+v, want %+v", n.id, m, n)
+		}
+		if !h.HasEdgeFromTo(g.root.ID(), n.id) {
+			t.Errorf("no edge from root to %v", n.id)
+		}
+	}
+
+	if _, err := UnmarshalTableGraph([]byte(`{"config": {}, "root": 0}`)); err == nil {
+		t.Error("organization without dimension decoded")
+	}
+}
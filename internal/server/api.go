@@ -0,0 +1,603 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	nav "github.com/DataIntelligenceCrew/OpenDataLink/internal/navigation"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
+)
+
+// Path prefix of the JSON API.
+const apiPrefix = "/api/v1/"
+
+var (
+	errMissingQuery    = errors.New("missing query parameter")
+	errNoOrganization  = errors.New("no such organization")
+	errNoFieldSearch   = errors.New("field-targeted search is disabled")
+	errInvalidOrganize = errors.New("organize must be a boolean")
+)
+
+// apiError is the body of JSON API error responses.
+type apiError struct {
+	Error struct {
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (s *Server) registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc(apiPrefix, s.handleAPINotFound)
+	mux.HandleFunc(apiPrefix+"openapi.yaml", s.handleAPISpec)
+	mux.HandleFunc(apiPrefix+"search", s.handleAPISearch)
+	mux.HandleFunc(apiPrefix+"datasets/", s.handleAPIDataset)
+	mux.HandleFunc(apiPrefix+"similar-datasets", s.handleAPISimilarDatasets)
+	mux.HandleFunc(apiPrefix+"joinable-columns", s.handleAPIJoinableColumns)
+	mux.HandleFunc(apiPrefix+"composite-joinable-columns", s.handleAPICompositeJoinableColumns)
+	mux.HandleFunc(apiPrefix+"unionable-tables", s.handleAPIUnionableTables)
+	mux.HandleFunc(apiPrefix+"join-paths", s.handleAPIJoinPaths)
+	mux.HandleFunc(apiPrefix+"upload", s.handleAPIUpload)
+	mux.HandleFunc(apiPrefix+"joinable-values", s.handleAPIJoinableValues)
+	mux.HandleFunc(apiPrefix+"organizations", s.handleAPIOrganizations)
+	mux.HandleFunc(apiPrefix+"organizations/", s.handleAPIOrganization)
+	mux.HandleFunc(apiPrefix+"navigation/", s.handleAPINav)
+	mux.HandleFunc(apiPrefix+"embedding-cache", s.handleAPIEmbeddingCache)
+}
+
+func (s *Server) handleAPINotFound(w http.ResponseWriter, req *http.Request) {
+	s.apiError(w, http.StatusNotFound, errors.New("no such endpoint"))
+}
+
+func (s *Server) handleAPISpec(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	http.ServeFile(w, req, "web/api/openapi.yaml")
+}
+
+// parseOrganize parses the organize parameter of an API request, which asks
+// for a navigation organization of the result datasets to be built.
+func parseOrganize(req *http.Request) (bool, error) {
+	v := req.FormValue("organize")
+	if v == "" {
+		return false, nil
+	}
+	organize, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errInvalidOrganize
+	}
+	return organize, nil
+}
+
+func (s *Server) handleAPISearch(w http.ResponseWriter, req *http.Request) {
+	query := req.FormValue("q")
+	if query == "" {
+		s.apiError(w, http.StatusBadRequest, errMissingQuery)
+		return
+	}
+	page, err := s.parsePage(req, keywordSearchPageSize)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	organize, err := parseOrganize(req)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter, err := s.parseSearchFilter(req.Form)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	results, err := s.keywordSearch(query, filter)
+	if err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	// Empty results are encoded as [], not null.
+	if results == nil {
+		results = []*searchResult{}
+	}
+	start, end := s.paginate(page, len(results))
+	results = results[:page.Total]
+
+	var orgID string
+	if organize {
+		if orgID, err = s.buildOrganization(query, searchResultIDs(results)); err != nil {
+			s.apiServerError(w, err)
+			return
+		}
+	}
+	s.serveJSON(w, &struct {
+		Query          string        `json:"query"`
+		Filter         *searchFilter `json:"filter"`
+		OrganizationID string        `json:"organization_id,omitempty"`
+		Facets         *searchFacets `json:"facets"`
+		*resultPage
+		Results []*searchResult `json:"results"`
+	}{query, filter, orgID, countFacets(results), page, results[start:end]})
+}
+
+func (s *Server) handleAPIDataset(w http.ResponseWriter, req *http.Request) {
+	datasetID := req.URL.Path[len(apiPrefix+"datasets/"):]
+
+	meta, err := s.db.Metadata(datasetID)
+	if err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	cols, err := s.db.DatasetColumns(datasetID)
+	if err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	if cols == nil {
+		cols = []*database.ColumnSketch{}
+	}
+	s.serveJSON(w, &struct {
+		*database.Metadata
+		Columns []*database.ColumnSketch `json:"columns"`
+	}{meta, cols})
+}
+
+func (s *Server) handleAPISimilarDatasets(w http.ResponseWriter, req *http.Request) {
+	queryID := req.FormValue("id")
+	if queryID == "" {
+		s.apiError(w, http.StatusBadRequest, errMissingQuery)
+		return
+	}
+	page, err := s.parsePage(req, similarDatasetsPageSize)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	results, err := s.similarDatasets(queryID)
+	if err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	if results == nil {
+		results = []*searchResult{}
+	}
+	start, end := s.paginate(page, len(results))
+
+	s.serveJSON(w, &struct {
+		DatasetID string `json:"dataset_id"`
+		*resultPage
+		Results []*searchResult `json:"results"`
+	}{queryID, page, results[start:end]})
+}
+
+func (s *Server) handleAPIJoinableColumns(w http.ResponseWriter, req *http.Request) {
+	columnID := req.FormValue("id")
+	if columnID == "" {
+		s.apiError(w, http.StatusBadRequest, errMissingQuery)
+		return
+	}
+	page, err := s.parsePage(req, joinableColumnsPageSize)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	organize, err := parseOrganize(req)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts, err := s.parseJoinabilityOptions(req)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	query, err := s.db.ColumnSketch(columnID)
+	if err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	results, verified, err := s.verifiedJoinableColumns(query, opts)
+	if err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	if results == nil {
+		results = []*joinabilityResult{}
+	}
+	start, end := s.paginate(page, len(results))
+	results = results[:page.Total]
+	if err := s.setJoinabilityDatasetNames(results[start:end]); err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	var orgID string
+	if organize {
+		datasetName, err := s.db.DatasetName(query.DatasetID)
+		if err != nil {
+			s.apiServerError(w, err)
+			return
+		}
+		if orgID, err = s.buildOrganization(datasetName, joinabilityResultIDs(results)); err != nil {
+			s.apiServerError(w, err)
+			return
+		}
+	}
+	s.serveJSON(w, &struct {
+		Query          *database.ColumnSketch `json:"query"`
+		Options        *joinabilityOptions    `json:"options"`
+		Verified       bool                   `json:"verified"`
+		OrganizationID string                 `json:"organization_id,omitempty"`
+		*resultPage
+		Results []*joinabilityResult `json:"results"`
+	}{query, opts, verified, orgID, page, results[start:end]})
+}
+
+func (s *Server) handleAPICompositeJoinableColumns(w http.ResponseWriter, req *http.Request) {
+	page, err := s.parsePage(req, joinableColumnsPageSize)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	organize, err := parseOrganize(req)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	threshold, err := s.parseThreshold(req)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	query, err := s.compositeQuery(req.URL.Query()["id"])
+	if err != nil {
+		switch err {
+		case errTooFewColumns, errMixedDatasets, errDuplicateColumn:
+			s.apiError(w, http.StatusBadRequest, err)
+		default:
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	results, err := s.compositeJoinableColumns(query, threshold)
+	if err != nil {
+		if err == errNoPairIndex {
+			s.apiError(w, http.StatusNotImplemented, err)
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	if results == nil {
+		results = []*compositeJoinabilityResult{}
+	}
+	start, end := s.paginate(page, len(results))
+	results = results[:page.Total]
+	if err := s.setCompositeJoinabilityDatasetNames(results[start:end]); err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	var orgID string
+	if organize {
+		datasetName, err := s.db.DatasetName(query[0].DatasetID)
+		if err != nil {
+			s.apiServerError(w, err)
+			return
+		}
+		if orgID, err = s.buildOrganization(datasetName, compositeJoinabilityResultIDs(results)); err != nil {
+			s.apiServerError(w, err)
+			return
+		}
+	}
+	s.serveJSON(w, &struct {
+		Query          []*database.ColumnSketch `json:"query"`
+		Threshold      float64                  `json:"threshold"`
+		OrganizationID string                   `json:"organization_id,omitempty"`
+		*resultPage
+		Results []*compositeJoinabilityResult `json:"results"`
+	}{query, threshold, orgID, page, results[start:end]})
+}
+
+func (s *Server) handleAPIUnionableTables(w http.ResponseWriter, req *http.Request) {
+	queryID := req.FormValue("id")
+	if queryID == "" {
+		s.apiError(w, http.StatusBadRequest, errMissingQuery)
+		return
+	}
+	page, err := s.parsePage(req, unionableTablesPageSize)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts, err := s.parseUnionOptions(req)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	results, err := s.unionableDatasets(queryID, opts)
+	if err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	if results == nil {
+		results = []*unionabilityResult{}
+	}
+	start, end := s.paginate(page, len(results))
+	results = results[start:end]
+	if err := s.setUnionabilityDatasetNames(results); err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	s.serveJSON(w, &struct {
+		DatasetID string        `json:"dataset_id"`
+		Options   *unionOptions `json:"options"`
+		*resultPage
+		Results []*unionabilityResult `json:"results"`
+	}{queryID, opts, page, results})
+}
+
+func (s *Server) handleAPIJoinPaths(w http.ResponseWriter, req *http.Request) {
+	from, to := req.FormValue("from"), req.FormValue("to")
+	if from == "" || to == "" {
+		s.apiError(w, http.StatusBadRequest, errMissingQuery)
+		return
+	}
+	page, err := s.parsePage(req, joinPathsPageSize)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	hops, err := parseHops(req)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	threshold, err := s.parseThreshold(req)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	results, err := s.joinPaths(from, to, hops, threshold)
+	if err != nil {
+		if err == errSameDataset {
+			s.apiError(w, http.StatusBadRequest, err)
+		} else {
+			s.apiServerError(w, err)
+		}
+		return
+	}
+	if results == nil {
+		results = []*joinPath{}
+	}
+	start, end := s.paginate(page, len(results))
+	results = results[start:end]
+	if err := s.setJoinPathDatasetNames(results); err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	s.serveJSON(w, &struct {
+		From      string  `json:"from"`
+		To        string  `json:"to"`
+		Hops      int     `json:"hops"`
+		Threshold float64 `json:"threshold"`
+		*resultPage
+		Results []*joinPath `json:"results"`
+	}{from, to, hops, threshold, page, results})
+}
+
+// handleAPIUpload serves the tables joinable and unionable with a CSV file
+// uploaded as a multipart form or as the request body.
+func (s *Server) handleAPIUpload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		s.apiError(w, http.StatusMethodNotAllowed,
+			errors.New(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+	page, err := s.parsePage(req, joinableColumnsPageSize)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts, err := s.parseUnionOptions(req)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	ts, fileName, err := readUpload(w, req)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	result, err := s.searchUpload(ts, fileName, page.Limit, opts)
+	if err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	s.serveJSON(w, result)
+}
+
+// handleAPIJoinableValues serves the columns containing a list of values
+// posted as the values form field or as a text/plain request body.
+func (s *Server) handleAPIJoinableValues(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		s.apiError(w, http.StatusMethodNotAllowed,
+			errors.New(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+	page, err := s.parsePage(req, joinableColumnsPageSize)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	values, err := readValues(w, req)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts, err := s.parseJoinabilityOptions(req)
+	if err != nil {
+		s.apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	results, err := s.joinableValues(values, opts)
+	if err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	if results == nil {
+		results = []*joinabilityResult{}
+	}
+	start, end := s.paginate(page, len(results))
+	results = results[start:end]
+	if err := s.setJoinabilityDatasetNames(results); err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	s.serveJSON(w, &struct {
+		ValueCount int                 `json:"value_count"`
+		Options    *joinabilityOptions `json:"options"`
+		*resultPage
+		Results []*joinabilityResult `json:"results"`
+	}{len(values), opts, page, results})
+}
+
+// handleAPINav serves a node of an organization.
+// The path is /api/v1/navigation/{organization ID}/{node ID}; the root node is
+// served if the node ID is empty.
+func (s *Server) handleAPINav(w http.ResponseWriter, req *http.Request) {
+	path := strings.SplitN(req.URL.Path[len(apiPrefix+"navigation/"):], "/", 2)
+	org, err := s.organization(path[0])
+	if err != nil {
+		s.apiServerError(w, err)
+		return
+	}
+	if org == nil {
+		s.apiError(w, http.StatusNotFound, errNoOrganization)
+		return
+	}
+	g, _ := org.result()
+	if g == nil {
+		s.apiError(w, http.StatusConflict,
+			fmt.Errorf("organization is %v", org.status().Status))
+		return
+	}
+	node := g.GetRootNode()
+	if len(path) == 2 && path[1] != "" {
+		nodeID, err := strconv.ParseInt(path[1], 10, 64)
+		if err != nil {
+			s.apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		if node = g.Node(nodeID); node == nil {
+			s.apiError(w, http.StatusNotFound, errors.New("no such node"))
+			return
+		}
+	}
+	s.serveJSON(w, nav.ToServeableNode(g, node))
+}
+
+// handleAPIOrganizations lists the saved organizations.
+func (s *Server) handleAPIOrganizations(w http.ResponseWriter, req *http.Request) {
+	orgs := []*database.Organization{}
+	if s.saveOrganizations {
+		var err error
+		if orgs, err = s.db.Organizations(); err != nil {
+			s.apiServerError(w, err)
+			return
+		}
+	}
+	s.serveJSON(w, &struct {
+		Organizations []*database.Organization `json:"organizations"`
+	}{orgs})
+}
+
+// handleAPIEmbeddingCache serves the statistics of the word vector cache of
+// the embedding model. The statistics are zero if the model does not cache
+// word vectors.
+func (s *Server) handleAPIEmbeddingCache(w http.ResponseWriter, req *http.Request) {
+	var stats wordemb.CacheStats
+	if m, ok := s.embedder.(interface{ CacheStats() wordemb.CacheStats }); ok {
+		stats = m.CacheStats()
+	}
+	s.serveJSON(w, &struct {
+		wordemb.CacheStats
+		HitRate float64 `json:"hit_rate"`
+	}{stats, stats.HitRate()})
+}
+
+// handleAPIOrganization serves the build status of an organization.
+// A DELETE request cancels building the organization and removes it from
+// memory. Saved organizations are not deleted.
+func (s *Server) handleAPIOrganization(w http.ResponseWriter, req *http.Request) {
+	id := req.URL.Path[len(apiPrefix+"organizations/"):]
+
+	switch req.Method {
+	case http.MethodGet:
+		org, err := s.organization(id)
+		if err != nil {
+			s.apiServerError(w, err)
+			return
+		}
+		if org == nil {
+			s.apiError(w, http.StatusNotFound, errNoOrganization)
+			return
+		}
+		s.serveJSON(w, org.status())
+	case http.MethodDelete:
+		if !s.organizations.remove(id) {
+			s.apiError(w, http.StatusNotFound, errNoOrganization)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		s.apiError(w, http.StatusMethodNotAllowed,
+			errors.New(http.StatusText(http.StatusMethodNotAllowed)))
+	}
+}
+
+// apiServerError responds with 404 Not Found if err indicates that the
+// requested dataset or column does not exist and with 500 Internal Server
+// Error otherwise.
+func (s *Server) apiServerError(w http.ResponseWriter, err error) {
+	if err == sql.ErrNoRows || err == errInvalidID {
+		s.apiError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	log.Print(err)
+	if !s.devMode {
+		err = errors.New(http.StatusText(http.StatusInternalServerError))
+	}
+	s.apiError(w, http.StatusInternalServerError, err)
+}
+
+func (s *Server) apiError(w http.ResponseWriter, status int, err error) {
+	var body apiError
+	body.Error.Status = status
+	body.Error.Message = err.Error()
+	s.writeJSON(w, status, &body)
+}
+
+func (s *Server) serveJSON(w http.ResponseWriter, data interface{}) {
+	s.writeJSON(w, http.StatusOK, data)
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(b); err != nil {
+		log.Print(err)
+	}
+}
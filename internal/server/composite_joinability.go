@@ -0,0 +1,379 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/sketch"
+	"github.com/ekzhu/lshensemble"
+)
+
+var (
+	errTooFewColumns   = errors.New("at least two columns are required")
+	errMixedDatasets   = errors.New("columns must be from the same dataset")
+	errDuplicateColumn = errors.New("columns must be distinct")
+	errNoPairIndex     = errors.New("composite key search is not available")
+)
+
+// columnMapping aligns a query column with a result column.
+type columnMapping struct {
+	QueryColumnID   string `json:"query_column_id"`
+	QueryColumnName string `json:"query_column_name"`
+	ColumnID        string `json:"column_id"`
+	ColumnName      string `json:"column_name"`
+}
+
+// compositeJoinabilityResult is a set of columns of a table that jointly
+// contain the tuples of the query columns.
+type compositeJoinabilityResult struct {
+	DatasetID   string `json:"dataset_id"`
+	DatasetName string `json:"dataset_name"`
+	// Result columns in the order of the query columns.
+	Mapping []*columnMapping `json:"mapping"`
+	// Containment of the query tuples, estimated from the pair sketches with
+	// two query columns and computed from the raw values with more.
+	Containment float64 `json:"containment"`
+	// Number of distinct query tuples in the columns.
+	Overlap int `json:"overlap"`
+	// Whether the containment and overlap are exact.
+	Exact bool `json:"exact"`
+}
+
+// compositeQuery returns the sketches of the query columns of a composite key
+// search. Returns sql.ErrNoRows if a column does not exist.
+func (s *Server) compositeQuery(columnIDs []string) ([]*database.ColumnSketch, error) {
+	if len(columnIDs) < 2 {
+		return nil, errTooFewColumns
+	}
+	query := make([]*database.ColumnSketch, len(columnIDs))
+	seen := make(map[string]bool)
+
+	for i, id := range columnIDs {
+		if seen[id] {
+			return nil, errDuplicateColumn
+		}
+		seen[id] = true
+
+		c, err := s.db.ColumnSketch(id)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && c.DatasetID != query[0].DatasetID {
+			return nil, errMixedDatasets
+		}
+		query[i] = c
+	}
+	return query, nil
+}
+
+// queryPairSketches returns the sketches of the value pairs of two columns of
+// a dataset in both orders: the pairs of a and b, and the pairs of b and a.
+// Only one order is sketched by sketch_columns; the other is sketched from the
+// dataset file.
+func (s *Server) queryPairSketches(a, b *database.ColumnSketch) ([2]*database.ColumnPairSketch, error) {
+	var sketches [2]*database.ColumnPairSketch
+
+	i, err := columnIndex(a.ColumnID)
+	if err != nil {
+		return sketches, err
+	}
+	j, err := columnIndex(b.ColumnID)
+	if err != nil {
+		return sketches, err
+	}
+	pair, err := s.db.ColumnPairSketch(sketch.PairID(a.DatasetID, i, j))
+	if err == nil {
+		// Pairs are stored in increasing column order.
+		if i < j {
+			sketches[0] = pair
+		} else {
+			sketches[1] = pair
+		}
+	} else if err != sql.ErrNoRows {
+		return sketches, err
+	}
+	var missing [][2]int
+	if sketches[0] == nil {
+		missing = append(missing, [2]int{i, j})
+	}
+	if sketches[1] == nil {
+		missing = append(missing, [2]int{j, i})
+	}
+	f, err := openRows(a.DatasetID)
+	if err != nil {
+		return sketches, err
+	}
+	defer f.Close()
+
+	pairs, err := sketch.SketchPairs(f, missing)
+	if err != nil {
+		return sketches, err
+	}
+	for _, p := range pairs {
+		if p.Columns[0] == i {
+			sketches[0] = p.Pair(a.DatasetID)
+		} else {
+			sketches[1] = p.Pair(a.DatasetID)
+		}
+	}
+	return sketches, nil
+}
+
+// pairCandidate is a pair of columns that contains the tuples of the first two
+// query columns.
+type pairCandidate struct {
+	pair *database.ColumnPairSketch
+	// Whether the pair contains the tuples in the reverse order.
+	reversed    bool
+	containment float64
+}
+
+// compositeJoinableColumns returns the sets of columns that jointly contain
+// the tuples of the query columns with containment of at least threshold,
+// sorted by containment. The dataset names of the results are not set.
+//
+// The column pair index is queried with the pairs of the first two query
+// columns in both orders. The remaining query columns are aligned with the
+// columns of the candidate tables by single column containment, and the
+// containment of the tuples of all query columns is computed from the dataset
+// files.
+func (s *Server) compositeJoinableColumns(query []*database.ColumnSketch, threshold float64) ([]*compositeJoinabilityResult, error) {
+	if s.columnPairIndex == nil {
+		return nil, errNoPairIndex
+	}
+	anchors, err := s.queryPairSketches(query[0], query[1])
+	if err != nil {
+		return nil, err
+	}
+	if anchors[0].DistinctCount == 0 {
+		return nil, nil
+	}
+	candidates, err := s.pairCandidates(anchors, threshold)
+	if err != nil {
+		return nil, err
+	}
+	var queryTuples map[string]bool
+	if len(query) > 2 {
+		if queryTuples, err = datasetColumnTuples(query[0].DatasetID, columnIDs(query)); err != nil {
+			return nil, err
+		}
+	}
+	var results []*compositeJoinabilityResult
+	// Maps dataset IDs to their columns.
+	datasetColumns := make(map[string][]*database.ColumnSketch)
+
+	for _, c := range candidates {
+		cols, ok := datasetColumns[c.pair.DatasetID]
+		if !ok {
+			if cols, err = s.db.DatasetColumns(c.pair.DatasetID); err != nil {
+				return nil, err
+			}
+			datasetColumns[c.pair.DatasetID] = cols
+		}
+		mapping := alignComposite(query, c.pair, c.reversed, cols, threshold)
+		if mapping == nil {
+			continue
+		}
+		res := &compositeJoinabilityResult{
+			DatasetID:   c.pair.DatasetID,
+			Mapping:     mapping,
+			Containment: c.containment,
+			Overlap: int(math.Round(
+				c.containment * float64(anchors[0].DistinctCount))),
+		}
+		if queryTuples != nil {
+			ids := make([]string, len(mapping))
+			for i, m := range mapping {
+				ids[i] = m.ColumnID
+			}
+			tuples, err := datasetColumnTuples(res.DatasetID, ids)
+			if err != nil {
+				log.Printf("verifying composite joinability with %v: %v", res.DatasetID, err)
+				continue
+			}
+			exact := exactContainmentOf(queryTuples, tuples)
+			if exact.Containment < threshold {
+				continue
+			}
+			res.Containment, res.Overlap, res.Exact = exact.Containment, exact.Overlap, true
+		}
+		results = append(results, res)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Containment > results[j].Containment
+	})
+	return results, nil
+}
+
+// pairCandidates queries the column pair index with the query pair sketches in
+// both orders and returns the pairs of columns that contain the query tuples
+// with estimated containment of at least threshold, in the order with the
+// larger containment.
+func (s *Server) pairCandidates(anchors [2]*database.ColumnPairSketch, threshold float64) ([]*pairCandidate, error) {
+	// Maps pair IDs to the candidates.
+	candidates := make(map[string]*pairCandidate)
+	var pairIDs []string
+
+	for i, anchor := range anchors {
+		for _, pairID := range s.queryPairIndex(anchor, threshold) {
+			if pairID == anchor.PairID {
+				continue
+			}
+			c, ok := candidates[pairID]
+			if !ok {
+				pair, err := s.db.ColumnPairSketch(pairID)
+				if err == sql.ErrNoRows {
+					// Removed since the index was last updated.
+					continue
+				} else if err != nil {
+					return nil, err
+				}
+				c = &pairCandidate{pair: pair, containment: -1}
+			}
+			containment := lshensemble.Containment(
+				anchor.Minhash, c.pair.Minhash, anchor.DistinctCount, c.pair.DistinctCount)
+			if containment < threshold || containment <= c.containment {
+				continue
+			}
+			if !ok {
+				candidates[pairID] = c
+				pairIDs = append(pairIDs, pairID)
+			}
+			c.reversed, c.containment = i == 1, containment
+		}
+	}
+	results := make([]*pairCandidate, len(pairIDs))
+	for i, id := range pairIDs {
+		results[i] = candidates[id]
+	}
+	return results, nil
+}
+
+// queryPairIndex returns the IDs of the column pairs that the column pair
+// index finds for the query pair sketch.
+func (s *Server) queryPairIndex(query *database.ColumnPairSketch, threshold float64) []string {
+	done := make(chan struct{})
+	defer close(done)
+
+	var pairIDs []string
+	for key := range s.columnPairIndex.Query(query.Minhash, query.DistinctCount, threshold, done) {
+		pairIDs = append(pairIDs, key.(string))
+	}
+	return pairIDs
+}
+
+// alignComposite aligns the query columns with the columns of a candidate
+// table whose pair of columns contains the tuples of the first two query
+// columns, in the reverse order if reversed is true.
+//
+// The first two query columns are aligned with the pair. Each remaining query
+// column is aligned with the unused column that contains it the most.
+// Returns nil if a remaining query column is contained in no column with at
+// least the threshold.
+func alignComposite(query []*database.ColumnSketch, pair *database.ColumnPairSketch, reversed bool, cols []*database.ColumnSketch, threshold float64) []*columnMapping {
+	byID := make(map[string]*database.ColumnSketch)
+	for _, c := range cols {
+		byID[c.ColumnID] = c
+	}
+	a, b := byID[pair.ColumnIDs[0]], byID[pair.ColumnIDs[1]]
+	if a == nil || b == nil {
+		return nil
+	}
+	if reversed {
+		a, b = b, a
+	}
+	aligned := []*database.ColumnSketch{a, b}
+	used := map[string]bool{a.ColumnID: true, b.ColumnID: true}
+
+	for _, q := range query[2:] {
+		var best *database.ColumnSketch
+		var bestContainment float64
+
+		for _, c := range cols {
+			if used[c.ColumnID] {
+				continue
+			}
+			if cc := columnContainment(q, c); best == nil || cc > bestContainment {
+				best, bestContainment = c, cc
+			}
+		}
+		if best == nil || bestContainment < threshold {
+			return nil
+		}
+		aligned = append(aligned, best)
+		used[best.ColumnID] = true
+	}
+	mapping := make([]*columnMapping, len(query))
+	for i, q := range query {
+		mapping[i] = &columnMapping{
+			QueryColumnID:   q.ColumnID,
+			QueryColumnName: q.ColumnName,
+			ColumnID:        aligned[i].ColumnID,
+			ColumnName:      aligned[i].ColumnName,
+		}
+	}
+	return mapping
+}
+
+// columnIDs returns the IDs of the columns.
+func columnIDs(cols []*database.ColumnSketch) []string {
+	ids := make([]string, len(cols))
+	for i, c := range cols {
+		ids[i] = c.ColumnID
+	}
+	return ids
+}
+
+// datasetColumnTuples reads the distinct tuples of the values of the given
+// columns of a dataset from its rows.csv file.
+func datasetColumnTuples(datasetID string, columnIDs []string) (map[string]bool, error) {
+	cols := make([]int, len(columnIDs))
+	for i, id := range columnIDs {
+		var err error
+		if cols[i], err = columnIndex(id); err != nil {
+			return nil, err
+		}
+	}
+	f, err := openRows(datasetID)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return sketch.ColumnTuples(f, cols)
+}
+
+// columnContainment returns the estimated containment of the query column in
+// column c.
+func columnContainment(query, c *database.ColumnSketch) float64 {
+	if query.DistinctCount == 0 {
+		return 0
+	}
+	return lshensemble.Containment(
+		query.Minhash, c.Minhash, query.DistinctCount, c.DistinctCount)
+}
+
+// setCompositeJoinabilityDatasetNames sets the dataset names of the results.
+func (s *Server) setCompositeJoinabilityDatasetNames(results []*compositeJoinabilityResult) error {
+	for _, res := range results {
+		name, err := s.db.DatasetName(res.DatasetID)
+		if err != nil {
+			return err
+		}
+		res.DatasetName = name
+	}
+	return nil
+}
+
+// compositeJoinabilityResultIDs returns the IDs of the datasets of the results
+// in order of their first appearance.
+func compositeJoinabilityResultIDs(results []*compositeJoinabilityResult) []string {
+	ids := make([]string, len(results))
+	for i, res := range results {
+		ids[i] = res.DatasetID
+	}
+	return uniqueDatasetIDs(ids)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/sketch"
+)
+
+func sketchColumn(columnID, name string, values ...string) *database.ColumnSketch {
+	cs := sketch.NewColumnSketch(name)
+	for _, v := range values {
+		cs.Update(v)
+	}
+	return cs.Column(columnID, "")
+}
+
+func TestAlignComposite(t *testing.T) {
+	var years, boroughs, stations []string
+	for i := 0; i < 50; i++ {
+		years = append(years, fmt.Sprint(2000+i))
+		boroughs = append(boroughs, fmt.Sprint("borough ", i))
+		stations = append(stations, fmt.Sprint("station ", i))
+	}
+	query := []*database.ColumnSketch{
+		sketchColumn("q-0", "year", years...),
+		sketchColumn("q-1", "borough", boroughs...),
+		sketchColumn("q-2", "station", stations...),
+	}
+	cols := []*database.ColumnSketch{
+		sketchColumn("r-0", "boro", boroughs...),
+		sketchColumn("r-1", "count", "1", "2", "3"),
+		sketchColumn("r-2", "yr", years...),
+		sketchColumn("r-3", "stop", stations...),
+	}
+	pair := &database.ColumnPairSketch{ColumnIDs: [2]string{"r-0", "r-2"}}
+
+	mapping := alignComposite(query, pair, true, cols, 0.5)
+	if mapping == nil {
+		t.Fatal("no mapping")
+	}
+	want := []string{"r-2", "r-0", "r-3"}
+	for i, m := range mapping {
+		if m.QueryColumnID != query[i].ColumnID || m.ColumnID != want[i] {
+			t.Errorf("%v aligned with %v, want %v", m.QueryColumnID, m.ColumnID, want[i])
+		}
+	}
+
+	cols[3] = sketchColumn("r-3", "stop", "other")
+	if mapping := alignComposite(query, pair, true, cols, 0.5); mapping != nil {
+		t.Errorf("got mapping %v, want none", mapping)
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+// updatableIndex is an index that can be updated with the changes of the
+// table it is built on.
+type updatableIndex interface {
+	Update(db *database.DB) (added, removed int, err error)
+}
+
+// UpdateIndexes applies the changes of the metadata_vectors,
+// metadata_field_vectors, column_sketches and column_pair_sketches tables
+// since the indexes were built or last updated. Searches made during the
+// update see each index either before or after its changes. An index that
+// fails to update is logged and left as it was, and the others are updated.
+func (s *Server) UpdateIndexes() error {
+	indexes := map[string]updatableIndex{"metadata embedding": s.metadataIndex}
+	if s.joinabilityIndex != nil {
+		indexes["joinability"] = s.joinabilityIndex
+	}
+	if s.columnPairIndex != nil {
+		indexes["column pair"] = s.columnPairIndex
+	}
+	for field, idx := range s.fieldIndexes {
+		indexes["metadata "+field+" embedding"] = idx
+	}
+	// The columns of the datasets may have changed.
+	s.unionFeatures.clear()
+	return updateIndexes(s.db, indexes)
+}
+
+// updateIndexes updates each of the indexes, keyed by name. Returns an error if
+// any of them failed to update.
+func updateIndexes(db *database.DB, indexes map[string]updatableIndex) error {
+	failed := 0
+	for name, idx := range indexes {
+		added, removed, err := idx.Update(db)
+		if err != nil {
+			log.Printf("updating %s index: %v", name, err)
+			failed++
+			continue
+		}
+		if added > 0 || removed > 0 {
+			log.Printf("updated %s index: %d added, %d removed", name, added, removed)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d indexes not updated", failed, len(indexes))
+	}
+	return nil
+}
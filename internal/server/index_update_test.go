@@ -0,0 +1,35 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+type fakeIndex struct {
+	err     error
+	updated bool
+}
+
+func (idx *fakeIndex) Update(db *database.DB) (int, int, error) {
+	idx.updated = true
+	return 1, 0, idx.err
+}
+
+func TestUpdateIndexesContinuesAfterError(t *testing.T) {
+	indexes := map[string]updatableIndex{
+		"a": &fakeIndex{err: errors.New("a failed")},
+		"b": &fakeIndex{},
+		"c": &fakeIndex{err: errors.New("c failed")},
+		"d": &fakeIndex{},
+	}
+	if err := updateIndexes(nil, indexes); err == nil {
+		t.Error("updateIndexes() = nil, want error")
+	}
+	for name, idx := range indexes {
+		if !idx.(*fakeIndex).updated {
+			t.Errorf("index %v not updated", name)
+		}
+	}
+}
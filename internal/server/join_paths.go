@@ -0,0 +1,246 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+const (
+	// Default and maximum number of joins in a join path.
+	defaultJoinPathHops = 2
+	maxJoinPathHops     = 3
+	// Number of partial paths extended at each hop.
+	joinPathBeamWidth = 20
+)
+
+var (
+	errInvalidHops = fmt.Errorf("hops must be an integer in [1, %d]", maxJoinPathHops)
+	errSameDataset = errors.New("from and to must be different datasets")
+)
+
+// joinPathColumn is a column in a join path.
+type joinPathColumn struct {
+	DatasetID   string `json:"dataset_id"`
+	DatasetName string `json:"dataset_name"`
+	ColumnID    string `json:"column_id"`
+	ColumnName  string `json:"column_name"`
+}
+
+func newJoinPathColumn(c *database.ColumnSketch) *joinPathColumn {
+	return &joinPathColumn{
+		DatasetID:  c.DatasetID,
+		ColumnID:   c.ColumnID,
+		ColumnName: c.ColumnName,
+	}
+}
+
+// joinStep is a join of two datasets on a column of each.
+type joinStep struct {
+	From *joinPathColumn `json:"from"`
+	To   *joinPathColumn `json:"to"`
+	// Estimated containment of the from column in the to column.
+	Containment float64 `json:"containment"`
+}
+
+// joinPath is a sequence of joins connecting two datasets.
+type joinPath struct {
+	Steps []*joinStep `json:"steps"`
+	// Product of the containments of the steps.
+	Score float64 `json:"score"`
+}
+
+// last returns the ID of the dataset at the end of the path.
+func (p *joinPath) last() string {
+	return p.Steps[len(p.Steps)-1].To.DatasetID
+}
+
+// visits reports whether the path passes through the dataset.
+func (p *joinPath) visits(datasetID string) bool {
+	for _, step := range p.Steps {
+		if step.From.DatasetID == datasetID || step.To.DatasetID == datasetID {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHops parses the hops parameter of req.
+func parseHops(req *http.Request) (int, error) {
+	v := req.FormValue("hops")
+	if v == "" {
+		return defaultJoinPathHops, nil
+	}
+	hops, err := strconv.Atoi(v)
+	if err != nil || hops < 1 || hops > maxJoinPathHops {
+		return 0, errInvalidHops
+	}
+	return hops, nil
+}
+
+// joinPaths returns the join paths of at most hops joins from dataset from to
+// dataset to, sorted by score. Each join has estimated containment of at least
+// threshold. The dataset names of the results are not set.
+func (s *Server) joinPaths(from, to string, hops int, threshold float64) ([]*joinPath, error) {
+	if from == to {
+		return nil, errSameDataset
+	}
+	for _, id := range []string{from, to} {
+		cols, err := s.db.DatasetColumns(id)
+		if err != nil {
+			return nil, err
+		} else if len(cols) == 0 {
+			return nil, errInvalidID
+		}
+	}
+	// Maps dataset IDs to their joins, which are computed once per search.
+	edges := make(map[string][]*joinStep)
+
+	return findJoinPaths(from, to, hops, func(datasetID string) ([]*joinStep, error) {
+		if e, ok := edges[datasetID]; ok {
+			return e, nil
+		}
+		e, err := s.joinEdges(datasetID, threshold)
+		if err != nil {
+			return nil, err
+		}
+		edges[datasetID] = e
+		return e, nil
+	})
+}
+
+// joinEdges returns the joins of the dataset with other datasets: for each
+// dataset with a column joinable with a column of the dataset, the join with
+// the largest containment. The joins are sorted by containment.
+func (s *Server) joinEdges(datasetID string, threshold float64) ([]*joinStep, error) {
+	cols, err := s.db.DatasetColumns(datasetID)
+	if err != nil {
+		return nil, err
+	}
+	// Maps dataset IDs to the best join with the dataset.
+	best := make(map[string]*joinStep)
+
+	for _, c := range cols {
+		if c.DistinctCount == 0 {
+			continue
+		}
+		results, err := s.joinableColumnsThreshold(c, threshold)
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range results {
+			if res.DatasetID == datasetID {
+				continue
+			}
+			if e := best[res.DatasetID]; e == nil || res.Containment > e.Containment {
+				best[res.DatasetID] = &joinStep{
+					From:        newJoinPathColumn(c),
+					To:          newJoinPathColumn(res.ColumnSketch),
+					Containment: res.Containment,
+				}
+			}
+		}
+	}
+	edges := make([]*joinStep, 0, len(best))
+	for _, e := range best {
+		edges = append(edges, e)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Containment != edges[j].Containment {
+			return edges[i].Containment > edges[j].Containment
+		}
+		return edges[i].To.DatasetID < edges[j].To.DatasetID
+	})
+	return edges, nil
+}
+
+// findJoinPaths searches for join paths of at most hops joins from dataset
+// from to dataset to, given a function returning the joins of a dataset.
+// Returns the paths sorted by score, and by number of joins for equal scores.
+//
+// The search is a beam search: at each hop, only the best partial path to
+// each dataset is kept, and only the joinPathBeamWidth best partial paths are
+// extended. Paths do not visit a dataset twice.
+func findJoinPaths(from, to string, hops int, edges func(datasetID string) ([]*joinStep, error)) ([]*joinPath, error) {
+	var results []*joinPath
+	// Partial paths to extend. The first hop extends the empty path.
+	partial := []*joinPath{{Score: 1}}
+
+	for hop := 0; hop < hops && len(partial) > 0; hop++ {
+		// Maps dataset IDs to the best partial path to the dataset.
+		next := make(map[string]*joinPath)
+
+		for _, p := range partial {
+			last := from
+			if len(p.Steps) > 0 {
+				last = p.last()
+			}
+			e, err := edges(last)
+			if err != nil {
+				return nil, err
+			}
+			for _, step := range e {
+				target := step.To.DatasetID
+				if target == from || p.visits(target) {
+					continue
+				}
+				steps := make([]*joinStep, len(p.Steps), len(p.Steps)+1)
+				copy(steps, p.Steps)
+				extended := &joinPath{
+					Steps: append(steps, step),
+					Score: p.Score * step.Containment,
+				}
+				if target == to {
+					results = append(results, extended)
+				} else if q := next[target]; q == nil || extended.Score > q.Score {
+					next[target] = extended
+				}
+			}
+		}
+		partial = partial[:0]
+		for _, p := range next {
+			partial = append(partial, p)
+		}
+		sort.Slice(partial, func(i, j int) bool {
+			if partial[i].Score != partial[j].Score {
+				return partial[i].Score > partial[j].Score
+			}
+			return partial[i].last() < partial[j].last()
+		})
+		if len(partial) > joinPathBeamWidth {
+			partial = partial[:joinPathBeamWidth]
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return len(results[i].Steps) < len(results[j].Steps)
+	})
+	return results, nil
+}
+
+// setJoinPathDatasetNames sets the dataset names of the columns of the paths.
+func (s *Server) setJoinPathDatasetNames(paths []*joinPath) error {
+	names := make(map[string]string)
+	for _, p := range paths {
+		for _, step := range p.Steps {
+			for _, c := range []*joinPathColumn{step.From, step.To} {
+				name, ok := names[c.DatasetID]
+				if !ok {
+					var err error
+					if name, err = s.db.DatasetName(c.DatasetID); err != nil {
+						return err
+					}
+					names[c.DatasetID] = name
+				}
+				c.DatasetName = name
+			}
+		}
+	}
+	return nil
+}
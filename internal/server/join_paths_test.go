@@ -0,0 +1,64 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindJoinPaths(t *testing.T) {
+	step := func(from, to string, containment float64) *joinStep {
+		return &joinStep{
+			From:        &joinPathColumn{DatasetID: from},
+			To:          &joinPathColumn{DatasetID: to},
+			Containment: containment,
+		}
+	}
+	graph := map[string][]*joinStep{
+		"a": {step("a", "x", 0.9), step("a", "b", 0.6), step("a", "y", 0.5)},
+		"x": {step("x", "b", 0.8), step("x", "a", 1), step("x", "y", 0.9)},
+		"y": {step("y", "b", 0.9), step("y", "x", 0.9)},
+	}
+	edges := func(datasetID string) ([]*joinStep, error) {
+		return graph[datasetID], nil
+	}
+	route := func(p *joinPath) []string {
+		r := []string{p.Steps[0].From.DatasetID}
+		for _, s := range p.Steps {
+			r = append(r, s.To.DatasetID)
+		}
+		return r
+	}
+
+	paths, err := findJoinPaths("a", "b", 2, edges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][]string
+	for _, p := range paths {
+		got = append(got, route(p))
+	}
+	want := [][]string{{"a", "x", "b"}, {"a", "b"}, {"a", "y", "b"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got paths %v, want %v", got, want)
+	}
+	if s := paths[0].Score; s < 0.7199 || s > 0.7201 {
+		t.Errorf("score = %v, want 0.72", s)
+	}
+
+	paths, err = findJoinPaths("a", "b", 3, edges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a-x-y-b (0.729) is better than a-x-b (0.72). The partial paths a-x and
+	// a-y-x are both extended, since they have different numbers of joins.
+	got = nil
+	for _, p := range paths {
+		got = append(got, route(p))
+	}
+	want = [][]string{
+		{"a", "x", "y", "b"}, {"a", "x", "b"}, {"a", "b"}, {"a", "y", "b"}, {"a", "y", "x", "b"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got paths %v, want %v", got, want)
+	}
+}
@@ -1,71 +1,263 @@
 package server
 
 import (
+	"container/heap"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
 	"sort"
+	"strconv"
 
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
 	"github.com/ekzhu/lshensemble"
 )
 
+// Joinable column search modes.
+const (
+	// Columns with containment of at least a threshold.
+	joinModeThreshold = "threshold"
+	// The k columns with the largest overlap.
+	joinModeTopK = "topk"
+)
+
+// Default number of results of top-k joinable column search.
+const defaultJoinTopK = 10
+
+// Containment thresholds with which top-k joinable column search queries the
+// joinability index, in order, until k columns are found. The LSH Ensemble
+// index cannot be queried without a threshold, so columns with containment
+// below the last one are only found if the index returns them.
+var topKThresholds = []float64{0.9, 0.75, 0.5, 0.3, 0.2, 0.1, 0.05}
+
+var (
+	errInvalidJoinMode = fmt.Errorf("mode must be %q or %q", joinModeThreshold, joinModeTopK)
+	errInvalidK        = errors.New("k must be a positive integer")
+)
+
 type joinabilityResult struct {
 	*database.ColumnSketch
-	DatasetName string
-	Containment float64
+	DatasetName string  `json:"dataset_name"`
+	Containment float64 `json:"containment"`
+	// Estimated number of distinct query values in the column.
+	EstimatedOverlap int `json:"estimated_overlap"`
+	// Set if the result was verified by exact containment.
+	Exact *exactContainment `json:"exact,omitempty"`
 }
 
-func (s *Server) joinableColumns(query *database.ColumnSketch) ([]*joinabilityResult, error) {
-	done := make(chan struct{})
-	defer close(done)
-	resultKeys := s.joinabilityIndex.Query(
-		query.Minhash, query.DistinctCount, s.joinabilityThreshold, done)
+// joinabilityOptions are the parameters of a joinable column search.
+type joinabilityOptions struct {
+	Mode string `json:"mode"`
+	// Minimum containment in threshold mode.
+	Threshold float64 `json:"threshold"`
+	// Number of results in top-k mode.
+	K int `json:"k"`
+	// Whether to verify the best results by exact containment.
+	Verify bool `json:"verify"`
+}
 
-	results := make([]*joinabilityResult, 0, len(resultKeys))
+// parseJoinabilityOptions parses the mode, threshold, k and verify parameters
+// of req.
+func (s *Server) parseJoinabilityOptions(req *http.Request) (*joinabilityOptions, error) {
+	opts := &joinabilityOptions{Mode: req.FormValue("mode"), K: defaultJoinTopK}
+	var err error
 
-	for key := range resultKeys {
-		colID := key.(string)
-		if colID == query.ColumnID {
-			continue
+	switch opts.Mode {
+	case "":
+		opts.Mode = joinModeThreshold
+	case joinModeThreshold, joinModeTopK:
+	default:
+		return nil, errInvalidJoinMode
+	}
+	if opts.Threshold, err = s.parseThreshold(req); err != nil {
+		return nil, err
+	}
+	if v := req.FormValue("k"); v != "" {
+		if opts.K, err = strconv.Atoi(v); err != nil || opts.K <= 0 {
+			return nil, errInvalidK
 		}
-		res, err := s.db.ColumnSketch(colID)
+	}
+	if opts.K > s.maxResults {
+		opts.K = s.maxResults
+	}
+	if opts.Verify, err = parseVerify(req); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// joinable returns the columns joinable with the query column according to
+// opts, sorted by containment. The results are not verified and their dataset
+// names are not set.
+func (s *Server) joinable(query *database.ColumnSketch, opts *joinabilityOptions) ([]*joinabilityResult, error) {
+	if opts.Mode == joinModeTopK {
+		return s.topKJoinableColumns(query, opts.K)
+	}
+	return s.joinableColumnsThreshold(query, opts.Threshold)
+}
+
+// joinableColumns returns the columns joinable with the query column, sorted by
+// containment. The dataset names of the results are not set.
+func (s *Server) joinableColumns(query *database.ColumnSketch) ([]*joinabilityResult, error) {
+	return s.joinableColumnsThreshold(query, s.joinabilityThreshold)
+}
+
+// joinableColumnsThreshold returns the columns whose estimated containment of
+// the query column is at least threshold, sorted by containment.
+// The dataset names of the results are not set.
+func (s *Server) joinableColumnsThreshold(query *database.ColumnSketch, threshold float64) ([]*joinabilityResult, error) {
+	var results []*joinabilityResult
+
+	for _, colID := range s.queryJoinabilityIndex(query, threshold) {
+		res, err := s.joinabilityResult(query, colID)
 		if err != nil {
 			return nil, err
 		}
-		containment := lshensemble.Containment(
-			query.Minhash, res.Minhash, query.DistinctCount, res.DistinctCount)
-		if containment < s.joinabilityThreshold {
+		if res == nil || res.Containment < threshold {
 			continue
 		}
-		datasetName, err := s.db.DatasetName(res.DatasetID)
-		if err != nil {
-			return nil, err
-		}
-		results = append(results, &joinabilityResult{res, datasetName, containment})
+		results = append(results, res)
 	}
 	sort.Slice(results, func(i, j int) bool {
 		return results[i].Containment > results[j].Containment
 	})
-	if len(results) == 0 {
-		return results, nil
+	return results, nil
+}
+
+// queryJoinabilityIndex returns the IDs of the columns that the joinability
+// index finds for the query column at threshold.
+func (s *Server) queryJoinabilityIndex(query *database.ColumnSketch, threshold float64) []string {
+	done := make(chan struct{})
+	defer close(done)
+
+	var colIDs []string
+	for key := range s.joinabilityIndex.Query(query.Minhash, query.DistinctCount, threshold, done) {
+		colIDs = append(colIDs, key.(string))
+	}
+	return colIDs
+}
+
+// joinabilityResult returns the column with the given ID as a result of a
+// joinable column search for the query column, or nil if it is the query
+// column or was removed since the index was last updated.
+func (s *Server) joinabilityResult(query *database.ColumnSketch, colID string) (*joinabilityResult, error) {
+	if colID == query.ColumnID {
+		return nil, nil
+	}
+	res, err := s.db.ColumnSketch(colID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
 	}
+	containment := lshensemble.Containment(
+		query.Minhash, res.Minhash, query.DistinctCount, res.DistinctCount)
+	return &joinabilityResult{
+		ColumnSketch: res,
+		Containment:  containment,
+		EstimatedOverlap: int(math.Round(
+			containment * float64(query.DistinctCount))),
+	}, nil
+}
 
-	orgDatasetIDs := make([]string, 0, 50)
-	added := make(map[string]bool)
+// topKJoinableColumns returns the k columns with the largest estimated overlap
+// with the query column, sorted by overlap.
+// The dataset names of the results are not set.
+func (s *Server) topKJoinableColumns(query *database.ColumnSketch, k int) ([]*joinabilityResult, error) {
+	return topKJoinable(k, topKThresholds,
+		func(threshold float64) []string {
+			return s.queryJoinabilityIndex(query, threshold)
+		},
+		func(colID string) (*joinabilityResult, error) {
+			return s.joinabilityResult(query, colID)
+		})
+}
 
-	for _, res := range results {
-		if !added[res.DatasetID] {
-			orgDatasetIDs = append(orgDatasetIDs, res.DatasetID)
-			added[res.DatasetID] = true
+// topKJoinable returns the k results with the largest containment, sorted by
+// containment.
+//
+// The column IDs returned by keys are looked up with result, which returns nil
+// for columns that are not results, for each of the decreasing thresholds
+// until k results with containment of at least the threshold are found. Since
+// the overlap is the containment times the query size, the columns found at a
+// threshold have larger overlap than those that are not. Each column is looked
+// up once, and only the best k results are kept.
+func topKJoinable(k int, thresholds []float64, keys func(threshold float64) []string, result func(colID string) (*joinabilityResult, error)) ([]*joinabilityResult, error) {
+	best := &joinabilityHeap{}
+	seen := make(map[string]bool)
+
+	for _, threshold := range thresholds {
+		for _, colID := range keys(threshold) {
+			if seen[colID] {
+				continue
+			}
+			seen[colID] = true
+
+			res, err := result(colID)
+			if err != nil {
+				return nil, err
+			}
+			if res == nil {
+				continue
+			}
+			if best.Len() < k {
+				heap.Push(best, res)
+			} else if res.Containment > (*best)[0].Containment {
+				(*best)[0] = res
+				heap.Fix(best, 0)
+			}
 		}
-		if len(orgDatasetIDs) == 50 {
+		if best.Len() == k && (*best)[0].Containment >= threshold {
 			break
 		}
 	}
-	name, err := s.db.DatasetName(query.DatasetID)
-	if err != nil {
-		return nil, err
-	}
-	if err := s.buildOrganization(name, orgDatasetIDs); err != nil {
-		return nil, err
+	results := make([]*joinabilityResult, best.Len())
+	for i := len(results) - 1; i >= 0; i-- {
+		results[i] = heap.Pop(best).(*joinabilityResult)
 	}
 	return results, nil
 }
+
+// joinabilityHeap is a min-heap of results by containment.
+// It implements the container/heap interface.
+type joinabilityHeap []*joinabilityResult
+
+func (h joinabilityHeap) Len() int           { return len(h) }
+func (h joinabilityHeap) Less(i, j int) bool { return h[i].Containment < h[j].Containment }
+func (h joinabilityHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *joinabilityHeap) Push(x interface{}) {
+	*h = append(*h, x.(*joinabilityResult))
+}
+
+func (h *joinabilityHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	res := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return res
+}
+
+// setJoinabilityDatasetNames sets the dataset names of the results.
+func (s *Server) setJoinabilityDatasetNames(results []*joinabilityResult) error {
+	for _, res := range results {
+		name, err := s.db.DatasetName(res.DatasetID)
+		if err != nil {
+			return err
+		}
+		res.DatasetName = name
+	}
+	return nil
+}
+
+// joinabilityResultIDs returns the IDs of the datasets containing the result
+// columns in order of their first appearance.
+func joinabilityResultIDs(results []*joinabilityResult) []string {
+	ids := make([]string, len(results))
+	for i, res := range results {
+		ids[i] = res.DatasetID
+	}
+	return uniqueDatasetIDs(ids)
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/sketch"
+)
+
+// Default number of joinability results verified by exact containment.
+const defaultVerifyResults = 20
+
+var errInvalidVerify = errors.New("verify must be a boolean")
+
+// exactContainment is the exact containment of a query column in a result
+// column, computed from the raw values.
+type exactContainment struct {
+	Containment float64 `json:"containment"`
+	// Number of distinct query values in the result column.
+	Overlap int `json:"overlap"`
+}
+
+// parseVerify parses the verify parameter of req.
+func parseVerify(req *http.Request) (bool, error) {
+	v := req.FormValue("verify")
+	if v == "" {
+		return false, nil
+	}
+	verify, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errInvalidVerify
+	}
+	return verify, nil
+}
+
+// verifiedJoinableColumns returns the columns joinable with the query column
+// according to opts, and whether they were verified. If opts.Verify is true,
+// the best results are verified by exact containment, unless the query
+// dataset cannot be read, in which case the results are left unverified.
+func (s *Server) verifiedJoinableColumns(query *database.ColumnSketch, opts *joinabilityOptions) ([]*joinabilityResult, bool, error) {
+	results, err := s.joinable(query, opts)
+	if err != nil || !opts.Verify {
+		return results, false, err
+	}
+	values, err := columnValues(query.DatasetID, query.ColumnID)
+	if err != nil {
+		log.Printf("verifying joinability of %v: %v", query.ColumnID, err)
+		return results, false, nil
+	}
+	verifyJoinability(values, results, s.verifyResults)
+	return results, true, nil
+}
+
+// columnIndex returns the index of a column in its dataset given its ID.
+func columnIndex(columnID string) (int, error) {
+	i := strings.LastIndexByte(columnID, '-')
+	if i < 0 {
+		return 0, fmt.Errorf("invalid column ID %q", columnID)
+	}
+	return strconv.Atoi(columnID[i+1:])
+}
+
+// datasetColumnValues reads the distinct values of the given columns of a
+// dataset from its rows.csv file.
+func datasetColumnValues(datasetID string, columnIDs []string) ([]map[string]bool, error) {
+	cols := make([]int, len(columnIDs))
+	for i, id := range columnIDs {
+		var err error
+		if cols[i], err = columnIndex(id); err != nil {
+			return nil, err
+		}
+	}
+	f, err := openRows(datasetID)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return sketch.ColumnValues(f, cols)
+}
+
+// openRows opens the rows.csv file of a dataset.
+func openRows(datasetID string) (*os.File, error) {
+	return os.Open(filepath.Join(config.DatasetsDir(), datasetID, "rows.csv"))
+}
+
+// columnValues reads the distinct values of a column from its dataset.
+func columnValues(datasetID, columnID string) (map[string]bool, error) {
+	values, err := datasetColumnValues(datasetID, []string{columnID})
+	if err != nil {
+		return nil, err
+	}
+	return values[0], nil
+}
+
+// verifyJoinability computes the exact containment of the query values in the
+// columns of the first n results and re-ranks them by exact containment,
+// ahead of the remaining results.
+// Results whose datasets cannot be read are left unverified.
+func verifyJoinability(query map[string]bool, results []*joinabilityResult, n int) {
+	if n > len(results) {
+		n = len(results)
+	}
+	verified := results[:n]
+
+	// Maps dataset IDs to the results in the dataset, so that each dataset
+	// is read once.
+	datasets := make(map[string][]*joinabilityResult)
+	var datasetIDs []string
+
+	for _, res := range verified {
+		if datasets[res.DatasetID] == nil {
+			datasetIDs = append(datasetIDs, res.DatasetID)
+		}
+		datasets[res.DatasetID] = append(datasets[res.DatasetID], res)
+	}
+	for _, datasetID := range datasetIDs {
+		var columnIDs []string
+		for _, res := range datasets[datasetID] {
+			columnIDs = append(columnIDs, res.ColumnID)
+		}
+		values, err := datasetColumnValues(datasetID, columnIDs)
+		if err != nil {
+			log.Printf("verifying joinability with %v: %v", datasetID, err)
+			continue
+		}
+		for i, res := range datasets[datasetID] {
+			res.Exact = exactContainmentOf(query, values[i])
+		}
+	}
+	sort.SliceStable(verified, func(i, j int) bool {
+		a, b := verified[i].Exact, verified[j].Exact
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return a.Containment > b.Containment
+	})
+}
+
+func exactContainmentOf(query, values map[string]bool) *exactContainment {
+	var overlap int
+	for v := range query {
+		if values[v] {
+			overlap++
+		}
+	}
+	var containment float64
+	if len(query) > 0 {
+		containment = float64(overlap) / float64(len(query))
+	}
+	return &exactContainment{containment, overlap}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+func TestColumnIndex(t *testing.T) {
+	if i, err := columnIndex("abcd-1234-12"); err != nil || i != 12 {
+		t.Errorf("columnIndex = %v, %v; want 12", i, err)
+	}
+	if _, err := columnIndex("abcd"); err == nil {
+		t.Error("columnIndex of invalid ID succeeded")
+	}
+}
+
+func TestExactContainment(t *testing.T) {
+	query := map[string]bool{"a": true, "b": true, "c": true, "d": true}
+	values := map[string]bool{"a": true, "c": true, "x": true}
+
+	got := exactContainmentOf(query, values)
+	if got.Overlap != 2 || got.Containment != 0.5 {
+		t.Errorf("exactContainmentOf = %+v, want overlap 2, containment 0.5", got)
+	}
+}
+
+func TestVerifyJoinability(t *testing.T) {
+	dir, err := ioutil.TempDir("", "datasets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "aaaa-0000"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	csv := "zip,name\n10001,a\n10002,b\n10003,c\n10001,d\n"
+	err = ioutil.WriteFile(filepath.Join(dir, "aaaa-0000", "rows.csv"), []byte(csv), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("OPENDATALINK_DATASETS", dir)
+	defer os.Unsetenv("OPENDATALINK_DATASETS")
+
+	res := func(datasetID, columnID string) *joinabilityResult {
+		return &joinabilityResult{ColumnSketch: &database.ColumnSketch{
+			DatasetID: datasetID,
+			ColumnID:  columnID,
+		}}
+	}
+	results := []*joinabilityResult{
+		res("bbbb-0000", "bbbb-0000-0"), // Missing dataset
+		res("aaaa-0000", "aaaa-0000-1"),
+		res("aaaa-0000", "aaaa-0000-0"),
+		res("cccc-0000", "cccc-0000-0"), // Not verified
+	}
+	query := map[string]bool{"10001": true, "10002": true, "a": true, "x": true}
+	verifyJoinability(query, results, 3)
+
+	want := []struct {
+		columnID string
+		overlap  int
+	}{
+		{"aaaa-0000-0", 2},
+		{"aaaa-0000-1", 1},
+		{"bbbb-0000-0", -1},
+		{"cccc-0000-0", -1},
+	}
+	for i, w := range want {
+		r := results[i]
+		overlap := -1
+		if r.Exact != nil {
+			overlap = r.Exact.Overlap
+		}
+		if r.ColumnID != w.columnID || overlap != w.overlap {
+			t.Errorf("result %v: got %v with overlap %v, want %v with overlap %v",
+				i, r.ColumnID, overlap, w.columnID, w.overlap)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/sketch"
+)
+
+var (
+	errNoValues         = errors.New("no values given")
+	errInvalidThreshold = errors.New("threshold must be a number in (0, 1]")
+)
+
+// readValues reads the newline-separated values posted with req, either as the
+// values form field or as a text/plain request body.
+// Leading and trailing whitespace is removed and empty lines are skipped.
+// Errors are caused by the request and should be reported as such.
+func readValues(w http.ResponseWriter, req *http.Request) ([]string, error) {
+	req.Body = http.MaxBytesReader(w, req.Body, maxUploadSize)
+
+	var text string
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType == "text/plain" {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		text = string(b)
+	} else {
+		if err := req.ParseMultipartForm(maxUploadSize); err != nil &&
+			err != http.ErrNotMultipart {
+			return nil, err
+		}
+		text = req.PostFormValue("values")
+	}
+	var values []string
+	for _, v := range strings.Split(text, "\n") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return nil, errNoValues
+	}
+	return values, nil
+}
+
+// parseThreshold parses the threshold parameter of req.
+// Returns the default joinability threshold if it is missing.
+func (s *Server) parseThreshold(req *http.Request) (float64, error) {
+	v := req.FormValue("threshold")
+	if v == "" {
+		return s.joinabilityThreshold, nil
+	}
+	t, err := strconv.ParseFloat(v, 64)
+	if err != nil || !(t > 0 && t <= 1) {
+		return 0, errInvalidThreshold
+	}
+	return t, nil
+}
+
+// valuesColumn sketches a column consisting of the distinct values.
+func valuesColumn(values []string) *database.ColumnSketch {
+	cs := sketch.NewColumnSketch("")
+	distinct := make(map[string]bool)
+
+	for _, v := range values {
+		if !distinct[v] {
+			cs.Update(v)
+			distinct[v] = true
+		}
+	}
+	col := cs.Column("", "")
+	col.DistinctCount = len(distinct)
+	return col
+}
+
+// joinableValues returns the columns joinable with a column of the distinct
+// values according to opts, sorted by containment.
+// If opts.Verify is true, the best results are verified by exact containment.
+// The dataset names of the results are not set.
+func (s *Server) joinableValues(values []string, opts *joinabilityOptions) ([]*joinabilityResult, error) {
+	results, err := s.joinable(valuesColumn(values), opts)
+	if err != nil || !opts.Verify {
+		return results, err
+	}
+	distinct := make(map[string]bool)
+	for _, v := range values {
+		distinct[v] = true
+	}
+	verifyJoinability(distinct, results, s.verifyResults)
+	return results, nil
+}
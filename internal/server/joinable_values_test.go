@@ -0,0 +1,140 @@
+package server
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+func TestReadValues(t *testing.T) {
+	body := "10001\r\n 11201 \n\n10001\n"
+	want := []string{"10001", "11201", "10001"}
+
+	req := httptest.NewRequest("POST", "/joinable-values", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	values, err := readValues(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("text body: got %q, want %q", values, want)
+	}
+
+	form := "values=" + strings.NewReplacer("\r", "%0D", "\n", "%0A", " ", "+").Replace(body)
+	req = httptest.NewRequest("POST", "/joinable-values", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	values, err = readValues(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("form: got %q, want %q", values, want)
+	}
+
+	req = httptest.NewRequest("POST", "/joinable-values", strings.NewReader(" \n"))
+	req.Header.Set("Content-Type", "text/plain")
+	if _, err := readValues(httptest.NewRecorder(), req); err != errNoValues {
+		t.Errorf("blank body: got error %v, want %v", err, errNoValues)
+	}
+}
+
+func TestValuesColumn(t *testing.T) {
+	col := valuesColumn([]string{"a", "b", "a", "c"})
+	if col.DistinctCount != 3 {
+		t.Errorf("distinct count = %v, want 3", col.DistinctCount)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(col.Sample, want) {
+		t.Errorf("sample = %q, want %q", col.Sample, want)
+	}
+}
+
+func TestParseJoinabilityOptions(t *testing.T) {
+	s := &Server{joinabilityThreshold: 0.5, maxResults: 100}
+	tests := []struct {
+		query string
+		want  *joinabilityOptions
+		err   error
+	}{
+		{"", &joinabilityOptions{Mode: joinModeThreshold, Threshold: 0.5, K: defaultJoinTopK}, nil},
+		{"threshold=0.8&verify=true", &joinabilityOptions{Mode: joinModeThreshold, Threshold: 0.8, K: defaultJoinTopK, Verify: true}, nil},
+		{"mode=topk&k=5", &joinabilityOptions{Mode: joinModeTopK, Threshold: 0.5, K: 5}, nil},
+		{"mode=topk&k=1000", &joinabilityOptions{Mode: joinModeTopK, Threshold: 0.5, K: 100}, nil},
+		{"mode=topk&k=0", nil, errInvalidK},
+		{"mode=best", nil, errInvalidJoinMode},
+		{"threshold=2", nil, errInvalidThreshold},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/joinable-columns?"+tt.query, nil)
+		opts, err := s.parseJoinabilityOptions(req)
+		if err != tt.err {
+			t.Errorf("%q: got error %v, want %v", tt.query, err, tt.err)
+		}
+		if !reflect.DeepEqual(opts, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.query, opts, tt.want)
+		}
+	}
+}
+
+func TestTopKJoinable(t *testing.T) {
+	// Estimated containments of the columns, which the index finds at the
+	// thresholds they reach.
+	containments := map[string]float64{"a": 0.95, "b": 0.8, "c": 0.4, "d": 0.2, "e": 0.06}
+	var queried []float64
+	keys := func(threshold float64) []string {
+		queried = append(queried, threshold)
+		var ids []string
+		for id, c := range containments {
+			if c >= threshold {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+	lookups := make(map[string]int)
+	result := func(colID string) (*joinabilityResult, error) {
+		lookups[colID]++
+		return &joinabilityResult{
+			ColumnSketch: &database.ColumnSketch{ColumnID: colID},
+			Containment:  containments[colID],
+		}, nil
+	}
+	ids := func(results []*joinabilityResult) []string {
+		var ids []string
+		for _, res := range results {
+			ids = append(ids, res.ColumnID)
+		}
+		return ids
+	}
+
+	results, err := topKJoinable(2, topKThresholds, keys, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(results), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("k=2: got %v, want %v", got, want)
+	}
+	if want := []float64{0.9, 0.75}; !reflect.DeepEqual(queried, want) {
+		t.Errorf("k=2: queried thresholds %v, want %v", queried, want)
+	}
+
+	// Columns below the joinability threshold are found with larger k.
+	queried = nil
+	results, err = topKJoinable(4, topKThresholds, keys, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(results), []string{"a", "b", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("k=4: got %v, want %v", got, want)
+	}
+	if n := len(queried); n != 5 {
+		t.Errorf("k=4: queried %v thresholds, want 5", n)
+	}
+	for id, n := range lookups {
+		if id != "a" && id != "b" && n != 1 {
+			t.Errorf("column %v looked up %v times, want once", id, n)
+		}
+	}
+}
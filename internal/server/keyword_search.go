@@ -1,57 +1,297 @@
 package server
 
 import (
+	"sort"
+	"strings"
+	"unicode"
+
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
 )
 
+// Rank constant of reciprocal rank fusion.
+const rrfK = 60
+
+// searchResult is a dataset returned by keyword or similar dataset search.
+type searchResult struct {
+	*database.Metadata
+	// Ranking score of the result; higher is better.
+	Score float64 `json:"score"`
+	// Cosine similarity of the metadata embedding vector to the query, if the
+	// dataset was found by semantic search.
+	Similarity float32 `json:"similarity,omitempty"`
+	// BM25 score of the metadata text, if the dataset was found by full-text
+	// search. Higher is better.
+	BM25 float64 `json:"bm25,omitempty"`
+}
+
 // keywordSearch performs a keyword search over the dataset metadata.
 //
-// It first tries a semantic search using the metadata embedding index and falls
-// back to an exact text search if none of the query words are found in the
-// fastText DB.
-// For semantic search, the 50 closest matches are returned.
-// Text search returns all matches.
-func (s *Server) keywordSearch(query string) ([]*database.Metadata, error) {
-	vec, err := wordemb.Vector(s.ft, []string{query})
+// If the database has a full-text index, it performs a hybrid search that fuses
+// the results of a semantic search using the metadata embedding index and a
+// BM25-ranked full-text search by reciprocal rank fusion.
+// Otherwise, it tries a semantic search and falls back to an exact text search
+// if none of the query words have an embedding.
+// Only datasets matching filter are searched, and the query is only matched
+// in the fields of the filter, if any.
+// The best matches are returned, up to one more than the maximum number of
+// results so that truncation can be detected.
+func (s *Server) keywordSearch(query string, filter *searchFilter) ([]*searchResult, error) {
+	if s.fullTextSearch {
+		return s.hybridSearch(query, filter)
+	}
+	vec, err := s.embedder.Embed([]string{query})
 	if err != nil {
 		if err == wordemb.ErrNoEmb {
-			return s.textSearch(query)
+			return s.textSearch(query, filter)
 		}
 		return nil, err
 	}
 
-	ids, _, err := s.metadataIndex.Query(vec, 50)
+	ids, scores, err := s.semanticQuery(vec, int64(s.maxResults+1), filter)
 	if err != nil {
 		return nil, err
 	}
-	var results []*database.Metadata
+	var results []*searchResult
 
-	for _, id := range ids {
+	for i, id := range ids {
 		meta, err := s.db.Metadata(id)
 		if err != nil {
 			return nil, err
 		}
-		results = append(results, meta)
+		results = append(results, &searchResult{
+			Metadata:   meta,
+			Score:      float64(scores[i]),
+			Similarity: scores[i],
+		})
 	}
+	return results, nil
+}
 
-	if err := s.buildOrganization(query, ids); err != nil {
+func (s *Server) hybridSearch(query string, filter *searchFilter) ([]*searchResult, error) {
+	var semanticIDs []string
+	var similarities []float32
+
+	vec, err := s.embedder.Embed([]string{query})
+	if err == nil {
+		semanticIDs, similarities, err = s.semanticQuery(vec, int64(s.maxResults+1), filter)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != wordemb.ErrNoEmb {
+		return nil, err
+	}
+	lexicalIDs, bm25, err := s.fullTextQuery(query, s.maxResults+1, filter)
+	if err != nil {
 		return nil, err
 	}
+
+	ids, scores := reciprocalRankFusion(semanticIDs, lexicalIDs)
+	if len(ids) > s.maxResults+1 {
+		ids = ids[:s.maxResults+1]
+	}
+	similarity := make(map[string]float32)
+	for i, id := range semanticIDs {
+		similarity[id] = similarities[i]
+	}
+	lexicalScore := make(map[string]float64)
+	for i, id := range lexicalIDs {
+		lexicalScore[id] = bm25[i]
+	}
+	var results []*searchResult
+
+	for i, id := range ids {
+		meta, err := s.db.Metadata(id)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, &searchResult{
+			Metadata:   meta,
+			Score:      scores[i],
+			Similarity: similarity[id],
+			BM25:       lexicalScore[id],
+		})
+	}
 	return results, nil
 }
 
-func (s *Server) textSearch(query string) ([]*database.Metadata, error) {
+// semanticQuery queries the metadata embedding index with vec, or the indexes
+// of the fields of the filter, considering only the datasets matching filter.
+// The similarity of a dataset to vec in several fields is its greatest
+// similarity in any of them.
+//
+// Returns the IDs of the (up to) k nearest datasets and their cosine
+// similarity, sorted by similarity.
+func (s *Server) semanticQuery(vec []float32, k int64, filter *searchFilter) ([]string, []float32, error) {
+	var allowed map[string]bool
+	if !filter.empty() {
+		var err error
+		if allowed, err = s.filteredDatasets(filter); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(filter.Fields) == 0 {
+		return queryMetadataIndex(s.metadataIndex, vec, k, allowed)
+	}
+	similarity := make(map[string]float32)
+	var ids []string
+
+	for _, field := range filter.Fields {
+		idx := s.fieldIndexes[field]
+		if idx == nil {
+			return nil, nil, errNoFieldSearch
+		}
+		fieldIDs, sims, err := queryMetadataIndex(idx, vec, k, allowed)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, id := range fieldIDs {
+			if sim, ok := similarity[id]; !ok {
+				ids = append(ids, id)
+				similarity[id] = sims[i]
+			} else if sims[i] > sim {
+				similarity[id] = sims[i]
+			}
+		}
+	}
+	sort.SliceStable(ids, func(i, j int) bool {
+		return similarity[ids[i]] > similarity[ids[j]]
+	})
+	if int64(len(ids)) > k {
+		ids = ids[:k]
+	}
+	sims := make([]float32, len(ids))
+	for i, id := range ids {
+		sims[i] = similarity[id]
+	}
+	return ids, sims, nil
+}
+
+// queryMetadataIndex queries idx with vec, considering only the allowed
+// datasets, or all datasets if allowed is nil.
+func queryMetadataIndex(idx *index.MetadataIndex, vec []float32, k int64, allowed map[string]bool) ([]string, []float32, error) {
+	if allowed == nil {
+		return idx.Query(vec, k)
+	}
+	return idx.QueryFiltered(vec, k, func(id string) bool {
+		return allowed[id]
+	})
+}
+
+// fullTextQuery queries the full-text index with the words of query,
+// considering only the datasets matching filter.
+//
+// Returns the IDs of the (up to) k best matching datasets and their BM25
+// scores, sorted by score. Matches on the dataset name are weighted highest.
+// Only the fields of the filter are matched, if any.
+func (s *Server) fullTextQuery(query string, k int, filter *searchFilter) ([]string, []float64, error) {
+	match := fullTextMatch(query)
+	if match == "" {
+		return nil, nil, nil
+	}
+	if len(filter.Fields) > 0 {
+		// The fields are columns of metadata_fts.
+		match = "{" + strings.Join(filter.Fields, " ") + "} : (" + match + ")"
+	}
+	where, args := filter.where()
+	// bm25 is smaller for better matches; the arguments are column weights.
+	rows, err := s.db.Query(`
+	SELECT dataset_id, -bm25(metadata_fts, 0, 10, 1, 5, 2, 2) AS score
+	FROM metadata_fts
+	WHERE metadata_fts MATCH ?
+		AND dataset_id IN (SELECT dataset_id FROM metadata WHERE `+where+`)
+	ORDER BY score DESC
+	LIMIT ?`, append(append([]interface{}{match}, args...), k)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	var scores []float64
+
+	for rows.Next() {
+		var id string
+		var score float64
+		if err := rows.Scan(&id, &score); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		scores = append(scores, score)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return ids, scores, nil
+}
+
+// fullTextMatch converts query into an FTS5 query that matches any of the
+// words in query.
+func fullTextMatch(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i, w := range words {
+		words[i] = `"` + w + `"`
+	}
+	return strings.Join(words, " OR ")
+}
+
+// reciprocalRankFusion combines rankings of dataset IDs.
+//
+// The score of a dataset is the sum of 1/(rrfK + rank) over the rankings it
+// appears in, where rank starts at 1.
+// Returns the dataset IDs sorted by score and the corresponding scores.
+func reciprocalRankFusion(rankings ...[]string) ([]string, []float64) {
+	score := make(map[string]float64)
+	var ids []string
+
+	for _, ranking := range rankings {
+		for rank, id := range ranking {
+			if _, ok := score[id]; !ok {
+				ids = append(ids, id)
+			}
+			score[id] += 1 / float64(rrfK+rank+1)
+		}
+	}
+	sort.SliceStable(ids, func(i, j int) bool {
+		return score[ids[i]] > score[ids[j]]
+	})
+	scores := make([]float64, len(ids))
+	for i, id := range ids {
+		scores[i] = score[id]
+	}
+	return ids, scores
+}
+
+// searchResultIDs returns the dataset IDs of the results.
+func searchResultIDs(results []*searchResult) []string {
+	ids := make([]string, len(results))
+	for i, res := range results {
+		ids[i] = res.DatasetID
+	}
+	return ids
+}
+
+func (s *Server) textSearch(query string, filter *searchFilter) ([]*searchResult, error) {
+	// The fields are columns of metadata.
+	text := "name || description"
+	if len(filter.Fields) > 0 {
+		text = strings.Join(filter.Fields, " || ' ' || ")
+	}
+	where, args := filter.where()
 	rows, err := s.db.Query(`
 	SELECT dataset_id
 	FROM metadata
-	WHERE name || description LIKE ?`, "%"+query+"%")
+	WHERE `+text+` LIKE ? AND `+where+`
+	LIMIT ?`, append(append([]interface{}{"%" + query + "%"}, args...), s.maxResults+1)...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var results []*database.Metadata
+	var results []*searchResult
 
 	for rows.Next() {
 		var datasetID string
@@ -62,7 +302,7 @@ func (s *Server) textSearch(query string) ([]*database.Metadata, error) {
 		if err != nil {
 			return nil, err
 		}
-		results = append(results, meta)
+		results = append(results, &searchResult{Metadata: meta})
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReciprocalRankFusion(t *testing.T) {
+	ids, scores := reciprocalRankFusion(
+		[]string{"a", "b", "c"},
+		[]string{"c", "d"},
+	)
+	want := []string{"c", "a", "b", "d"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("ids = %v, want %v", ids, want)
+	}
+	if c := 1.0/(rrfK+3) + 1.0/(rrfK+1); scores[0] != c {
+		t.Errorf("score of c = %v, want %v", scores[0], c)
+	}
+}
+
+func TestFullTextMatch(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{"", ""},
+		{"NYPD", `"NYPD"`},
+		{`school "districts" 2019-2020`, `"school" OR "districts" OR "2019" OR "2020"`},
+		{"Département", `"Département"`},
+	}
+	for _, tt := range tests {
+		if got := fullTextMatch(tt.query); got != tt.want {
+			t.Errorf("fullTextMatch(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
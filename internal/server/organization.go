@@ -2,42 +2,471 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
 	"os/exec"
+	"sync"
 	"time"
 
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/navigation"
 )
 
-func (s *Server) buildOrganization(name string, datasetIDs []string) error {
+const (
+	// Default number of organizations kept in the organization cache.
+	defaultOrganizationCacheSize = 100
+	// Default time after which a cached organization expires.
+	defaultOrganizationTTL = time.Hour
+	// Default number of goroutines building organizations.
+	defaultOrganizeWorkers = 2
+	// Maximum number of organizations waiting to be built.
+	organizeQueueSize = 100
+	// Maximum number of datasets in an organization.
+	maxOrganizationSize = 50
+)
+
+// Organization build states.
+const (
+	organizationQueued  = "queued"
+	organizationRunning = "running"
+	organizationDone    = "done"
+	organizationFailed  = "failed"
+)
+
+var errOrganizeQueueFull = errors.New("organization queue is full")
+
+// organization is a navigation organization built for a search.
+// It is built in the background by an organize worker.
+type organization struct {
+	id         string
+	key        string // See organizationKey
+	name       string
+	datasetIDs []string
+	ctx        context.Context
+	cancel     context.CancelFunc
+	expires    time.Time // Guarded by the organizationCache mutex
+
+	mu            sync.Mutex // Guards the fields below
+	state         string
+	err           error
+	iterations    int
+	effectiveness float64
+	graph         *navigation.TableGraph
+	graphSVG      []byte
+}
+
+// organizationStatus reports the progress of building an organization.
+type organizationStatus struct {
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	Status        string  `json:"status"`
+	Iterations    int     `json:"iterations"`
+	Effectiveness float64 `json:"effectiveness"`
+	Error         string  `json:"error,omitempty"`
+}
+
+func newOrganization(name string, datasetIDs []string) (*organization, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &organization{
+		id:         hex.EncodeToString(b),
+		name:       name,
+		datasetIDs: datasetIDs,
+		ctx:        ctx,
+		cancel:     cancel,
+		state:      organizationQueued,
+	}, nil
+}
+
+func (org *organization) status() *organizationStatus {
+	org.mu.Lock()
+	defer org.mu.Unlock()
+
+	status := &organizationStatus{
+		ID:            org.id,
+		Name:          org.name,
+		Status:        org.state,
+		Iterations:    org.iterations,
+		Effectiveness: org.effectiveness,
+	}
+	if org.err != nil {
+		status.Error = org.err.Error()
+	}
+	return status
+}
+
+// result returns the organization graph and its SVG rendering, or nil if the
+// organization has not been built.
+func (org *organization) result() (*navigation.TableGraph, []byte) {
+	org.mu.Lock()
+	defer org.mu.Unlock()
+	return org.graph, org.graphSVG
+}
+
+func (org *organization) setState(state string) {
+	org.mu.Lock()
+	defer org.mu.Unlock()
+	org.state = state
+}
+
+func (org *organization) setProgress(iterations int, effectiveness float64) {
+	org.mu.Lock()
+	defer org.mu.Unlock()
+	org.iterations, org.effectiveness = iterations, effectiveness
+}
+
+func (org *organization) fail(err error) {
+	org.mu.Lock()
+	defer org.mu.Unlock()
+	org.state, org.err = organizationFailed, err
+}
+
+func (org *organization) finish(g *navigation.TableGraph, svg []byte) {
+	org.mu.Lock()
+	defer org.mu.Unlock()
+	org.state, org.graph, org.graphSVG = organizationDone, g, svg
+}
+
+// organizationCache is a bounded cache of organizations keyed by their ID,
+// which can also be looked up by their organizationKey.
+// Organizations are evicted when they expire or, if the cache is full, in
+// order of expiry. Building an evicted organization is canceled.
+// It is safe for concurrent use.
+type organizationCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*organization
+	// Maps organization keys to the organizations.
+	byKey map[string]*organization
+}
+
+func newOrganizationCache(size int, ttl time.Duration) *organizationCache {
+	if size <= 0 {
+		size = defaultOrganizationCacheSize
+	}
+	if ttl <= 0 {
+		ttl = defaultOrganizationTTL
+	}
+	return &organizationCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*organization),
+		byKey:   make(map[string]*organization),
+	}
+}
+
+// get returns the organization with the given ID or nil if there is no such
+// organization or it has expired.
+func (c *organizationCache) get(id string) *organization {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	org := c.entries[id]
+	if org == nil {
+		return nil
+	}
+	if time.Now().After(org.expires) {
+		c.evict(org)
+		return nil
+	}
+	return org
+}
+
+// getByKey returns the organization with the given key, whether it is queued,
+// being built or built, or nil if there is no such organization, it has
+// expired or it failed to build.
+func (c *organizationCache) getByKey(key string) *organization {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lookupKey(key)
+}
+
+// lookupKey must be called with c.mu held.
+func (c *organizationCache) lookupKey(key string) *organization {
+	org := c.byKey[key]
+	if org == nil {
+		return nil
+	}
+	if time.Now().After(org.expires) {
+		c.evict(org)
+		return nil
+	}
+	if org.status().Status == organizationFailed {
+		return nil
+	}
+	return org
+}
+
+// add adds org to the cache and sets its expiry time.
+func (c *organizationCache) add(org *organization) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.insert(org)
+}
+
+// addOrGet adds org to the cache as add does, unless an organization with the
+// same key that has not failed is cached, in which case that organization is
+// returned instead. Otherwise, org is returned.
+func (c *organizationCache) addOrGet(org *organization) *organization {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached := c.lookupKey(org.key); cached != nil {
+		return cached
+	}
+	c.insert(org)
+	return org
+}
+
+// insert must be called with c.mu held.
+func (c *organizationCache) insert(org *organization) {
+	now := time.Now()
+	org.expires = now.Add(c.ttl)
+
+	for _, o := range c.entries {
+		if now.After(o.expires) {
+			c.evict(o)
+		}
+	}
+	for len(c.entries) >= c.size {
+		var oldest *organization
+		for _, o := range c.entries {
+			if oldest == nil || o.expires.Before(oldest.expires) {
+				oldest = o
+			}
+		}
+		c.evict(oldest)
+	}
+	c.entries[org.id] = org
+	if org.key != "" {
+		c.byKey[org.key] = org
+	}
+}
+
+// remove removes the organization with the given ID from the cache.
+// Returns false if there is no such organization.
+func (c *organizationCache) remove(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	org := c.entries[id]
+	if org == nil {
+		return false
+	}
+	c.evict(org)
+	return true
+}
+
+// evict must be called with c.mu held.
+func (c *organizationCache) evict(org *organization) {
+	org.cancel()
+	delete(c.entries, org.id)
+	if c.byKey[org.key] == org {
+		delete(c.byKey, org.key)
+	}
+}
+
+// organizationKey returns a key identifying the organization of the given
+// datasets with the given name and configuration.
+func organizationKey(name string, cfg *navigation.Config, datasetIDs []string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %+v", name, *cfg)
+	for _, id := range datasetIDs {
+		fmt.Fprintf(h, " %q", id)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// buildOrganization queues building an organization of the given datasets,
+// adds it to the organization cache and returns its ID.
+//
+// If an organization with the same name and datasets is in the cache, its ID
+// is returned instead. If organizations are saved and such an organization
+// was saved before, the saved organization is loaded.
+// Only the first maxOrganizationSize datasets are organized.
+// Returns an empty ID if there are fewer than two datasets to organize.
+func (s *Server) buildOrganization(name string, datasetIDs []string) (string, error) {
+	if len(datasetIDs) > maxOrganizationSize {
+		datasetIDs = datasetIDs[:maxOrganizationSize]
+	}
+	if len(datasetIDs) < 2 {
+		return "", nil
+	}
+	key := organizationKey(name, s.organizationConfig, datasetIDs)
+	if org := s.organizations.getByKey(key); org != nil {
+		return org.id, nil
+	}
+
+	if s.saveOrganizations {
+		saved, err := s.db.OrganizationByKey(key)
+		if err == nil {
+			org := s.organizations.get(saved.OrganizationID)
+			if org == nil {
+				if org, err = s.loadOrganization(saved); err != nil {
+					return "", err
+				}
+			}
+			return org.id, nil
+		} else if err != sql.ErrNoRows {
+			return "", err
+		}
+	}
+	org, err := newOrganization(name, datasetIDs)
+	if err != nil {
+		return "", err
+	}
+	org.key = key
+	// Concurrent requests for the same organization queue it once.
+	if cached := s.organizations.addOrGet(org); cached != org {
+		org.cancel()
+		return cached.id, nil
+	}
+
+	select {
+	case s.organizeJobs <- org:
+	default:
+		org.fail(errOrganizeQueueFull)
+	}
+	return org.id, nil
+}
+
+// organization returns the organization with the given ID from the
+// organization cache or, if organizations are saved, from the database.
+// Returns nil if there is no such organization.
+func (s *Server) organization(id string) (*organization, error) {
+	if org := s.organizations.get(id); org != nil || !s.saveOrganizations {
+		return org, nil
+	}
+	saved, err := s.db.Organization(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return s.loadOrganization(saved)
+}
+
+// loadOrganization decodes a saved organization and adds it to the
+// organization cache.
+func (s *Server) loadOrganization(saved *database.Organization) (*organization, error) {
+	g, err := navigation.UnmarshalTableGraph(saved.Graph)
+	if err != nil {
+		return nil, err
+	}
+	org, err := newOrganization(saved.Name, nil)
+	if err != nil {
+		return nil, err
+	}
+	org.id, org.key = saved.OrganizationID, saved.OrganizationKey
+
+	svg, err := organizationGraphSVG(g, "/navigation/"+org.id+"/")
+	if err != nil {
+		return nil, err
+	}
+	org.finish(g, svg)
+	s.organizations.add(org)
+	return org, nil
+}
+
+// saveOrganization saves a built organization in the database.
+func (s *Server) saveOrganization(org *organization, g *navigation.TableGraph) error {
+	data, err := g.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return s.db.InsertOrganization(&database.Organization{
+		OrganizationID:  org.id,
+		OrganizationKey: org.key,
+		Name:            org.name,
+		DatasetCount:    g.DatasetCount(),
+		CreatedAt:       time.Now().UTC().Format(time.RFC3339),
+		Graph:           data,
+	})
+}
+
+// organizeWorker builds the organizations received from jobs.
+func (s *Server) organizeWorker(jobs <-chan *organization) {
+	for org := range jobs {
+		if org.ctx.Err() != nil {
+			org.fail(org.ctx.Err())
+			continue
+		}
+		org.setState(organizationRunning)
+		if err := s.organize(org); err != nil {
+			log.Printf("organization %q: %v", org.name, err)
+			org.fail(err)
+		}
+	}
+}
+
+func (s *Server) organize(org *organization) error {
 	start := time.Now()
-	var err error
-	s.organization, err = navigation.BuildOrganization(
-		s.db, s.ft, s.organizationConfig, datasetIDs)
+	g, err := navigation.BuildOrganization(
+		org.ctx, s.db, s.embedder, s.organizationConfig, org.datasetIDs, org.setProgress)
 	if err != nil {
 		return err
 	}
-	log.Printf("built organization %q in %v", name, time.Since(start).String())
-	s.organization.SetRootName(name)
+	log.Printf("built organization %q in %v", org.name, time.Since(start).String())
+	g.SetRootName(org.name)
 
-	dot, err := s.organization.MarshalDOT()
+	svg, err := organizationGraphSVG(g, "/navigation/"+org.id+"/")
 	if err != nil {
 		return err
 	}
+	org.finish(g, svg)
+
+	if s.saveOrganizations {
+		if err := s.saveOrganization(org, g); err != nil {
+			log.Printf("saving organization %q: %v", org.name, err)
+		}
+	}
+	return nil
+}
+
+// organizationGraphSVG renders the organization graph as SVG using the dot
+// command. Nodes link to nodeURL followed by the node ID.
+func organizationGraphSVG(g *navigation.TableGraph, nodeURL string) ([]byte, error) {
+	dot, err := g.MarshalDOT(nodeURL)
+	if err != nil {
+		return nil, err
+	}
 	var out bytes.Buffer
 	cmd := exec.Command("dot", "-Tsvg")
 	cmd.Stdin = bytes.NewReader(dot)
 	cmd.Stdout = &out
 	if err := cmd.Run(); err != nil {
-		return err
+		return nil, err
 	}
 	svg := out.Bytes()
 	i := bytes.Index(svg, []byte("<svg"))
 	if i < 0 {
-		return errors.New("<svg not found")
+		return nil, errors.New("<svg not found")
 	}
-	svg = svg[i:]
-	s.organizationGraphSVG = svg
-	return nil
+	return svg[i:], nil
+}
+
+// uniqueDatasetIDs returns the IDs of the datasets in the order in which they
+// first appear.
+func uniqueDatasetIDs(ids []string) []string {
+	var out []string
+	added := make(map[string]bool)
+
+	for _, id := range ids {
+		if !added[id] {
+			out = append(out, id)
+			added[id] = true
+		}
+	}
+	return out
 }
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/navigation"
+)
+
+func TestOrganizationCacheEviction(t *testing.T) {
+	c := newOrganizationCache(2, time.Hour)
+	var orgs []*organization
+	for i := 0; i < 3; i++ {
+		org, err := newOrganization(fmt.Sprint(i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.add(org)
+		orgs = append(orgs, org)
+	}
+	if c.get(orgs[0].id) != nil {
+		t.Error("oldest organization not evicted")
+	}
+	if orgs[0].ctx.Err() == nil {
+		t.Error("evicted organization not canceled")
+	}
+	for _, org := range orgs[1:] {
+		if c.get(org.id) == nil {
+			t.Errorf("organization %v evicted", org.name)
+		}
+	}
+}
+
+func TestOrganizationCacheExpiry(t *testing.T) {
+	c := newOrganizationCache(2, time.Millisecond)
+	org, err := newOrganization("a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.add(org)
+	time.Sleep(2 * time.Millisecond)
+	if c.get(org.id) != nil {
+		t.Error("expired organization returned")
+	}
+}
+
+func TestBuildOrganizationOnce(t *testing.T) {
+	s := &Server{
+		organizations:      newOrganizationCache(10, time.Hour),
+		organizationConfig: &navigation.Config{},
+		organizeJobs:       make(chan *organization, 10),
+	}
+	ids := []string{"aaaa-aaaa", "bbbb-bbbb"}
+	first, err := s.buildOrganization("parks", ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.buildOrganization("parks", ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == "" || second != first {
+		t.Errorf("got organization IDs %q and %q, want one ID", first, second)
+	}
+	if n := len(s.organizeJobs); n != 1 {
+		t.Errorf("%v organizations queued, want 1", n)
+	}
+
+	// Organizations that failed to build are built again.
+	s.organizations.get(first).fail(errOrganizeQueueFull)
+	if third, err := s.buildOrganization("parks", ids); err != nil || third == first {
+		t.Errorf("got organization ID %q, %v; want a new ID", third, err)
+	}
+}
+
+func TestBuildOrganizationConcurrent(t *testing.T) {
+	s := &Server{
+		organizations:      newOrganizationCache(10, time.Hour),
+		organizationConfig: &navigation.Config{},
+		organizeJobs:       make(chan *organization, 10),
+	}
+	ids := []string{"aaaa-aaaa", "bbbb-bbbb"}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.buildOrganization("parks", ids); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(s.organizeJobs); n != 1 {
+		t.Errorf("%v organizations queued, want 1", n)
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	// Default maximum number of results ranked by a search.
+	defaultMaxResults = 500
+	// Default maximum number of results in a page.
+	defaultMaxPageSize = 100
+)
+
+// Default number of results in a page of each search mode.
+const (
+	keywordSearchPageSize   = 50
+	similarDatasetsPageSize = 20
+	joinableColumnsPageSize = 50
+	unionableTablesPageSize = 50
+	joinPathsPageSize       = 20
+)
+
+var errInvalidPage = errors.New("limit and offset must be non-negative integers")
+
+// resultPage is a page of search results.
+type resultPage struct {
+	// Number of results ranked by the search.
+	Total int `json:"total"`
+	// Whether there were more than the maximum number of results, in which
+	// case only the best results were ranked.
+	Truncated bool `json:"truncated,omitempty"`
+	Offset    int  `json:"offset"`
+	Limit     int  `json:"limit"`
+	// URL and query parameters of the request, used for links to other pages.
+	url *url.URL
+}
+
+// parsePage parses the limit and offset URL query parameters of req.
+//
+// The limit defaults to defaultLimit if it is missing or zero and is capped at
+// the maximum page size.
+func (s *Server) parsePage(req *http.Request, defaultLimit int) (*resultPage, error) {
+	p := &resultPage{Limit: defaultLimit, url: req.URL}
+	// The parameters are read from the URL so that the body of POST requests
+	// is not consumed.
+	query := req.URL.Query()
+
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, errInvalidPage
+		}
+		if n > 0 {
+			p.Limit = n
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, errInvalidPage
+		}
+		p.Offset = n
+	}
+	if p.Limit > s.maxPageSize {
+		p.Limit = s.maxPageSize
+	}
+	return p, nil
+}
+
+// paginate sets the total number of results to n, capped at the maximum
+// number of results, and returns the bounds of the page in the results.
+func (s *Server) paginate(p *resultPage, n int) (start, end int) {
+	if n > s.maxResults {
+		n, p.Truncated = s.maxResults, true
+	}
+	p.Total = n
+
+	start = p.Offset
+	if start > n {
+		start = n
+	}
+	return start, start + p.size()
+}
+
+// size returns the number of results in the page.
+func (p *resultPage) size() int {
+	if p.Offset >= p.Total {
+		return 0
+	}
+	// Offset+Limit may overflow.
+	if n := p.Total - p.Offset; p.Limit > n {
+		return n
+	}
+	return p.Limit
+}
+
+// Start returns the 1-based position of the first result of the page, or 0 if
+// there are no results.
+func (p *resultPage) Start() int {
+	if p.Total == 0 {
+		return 0
+	}
+	return p.Offset + 1
+}
+
+// End returns the 1-based position of the last result of the page.
+func (p *resultPage) End() int {
+	if p.Offset >= p.Total {
+		return p.Total
+	}
+	return p.Offset + p.size()
+}
+
+// PrevURL returns the URL of the previous page or "" if this is the first
+// page.
+func (p *resultPage) PrevURL() string {
+	if p.Offset == 0 {
+		return ""
+	}
+	offset := p.Offset - p.Limit
+	if last := p.Total - p.Limit; offset > last {
+		offset = last
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return p.pageURL(offset)
+}
+
+// NextURL returns the URL of the next page or "" if this is the last page.
+func (p *resultPage) NextURL() string {
+	if p.Limit >= p.Total-p.Offset {
+		return ""
+	}
+	return p.pageURL(p.Offset + p.Limit)
+}
+
+func (p *resultPage) pageURL(offset int) string {
+	if p.url == nil {
+		return ""
+	}
+	v := p.url.Query()
+	v.Set("offset", strconv.Itoa(offset))
+	v.Set("limit", strconv.Itoa(p.Limit))
+	u := *p.url
+	u.RawQuery = v.Encode()
+	return u.RequestURI()
+}
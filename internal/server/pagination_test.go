@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	s := &Server{maxResults: 100, maxPageSize: 20}
+
+	tests := []struct {
+		url               string
+		n                 int
+		start, end, total int
+		truncated         bool
+		prev, next        string
+	}{
+		{"/search?q=x", 10, 0, 10, 10, false, "", ""},
+		{"/search?q=x", 50, 0, 15, 50, false, "", "/search?limit=15&offset=15&q=x"},
+		{"/search?q=x&offset=40", 50, 40, 50, 50, false, "/search?limit=15&offset=25&q=x", ""},
+		{"/search?q=x&limit=50&offset=5", 200, 5, 25, 100, true, "/search?limit=20&offset=0&q=x", "/search?limit=20&offset=25&q=x"},
+		{"/search?q=x&offset=200", 200, 100, 100, 100, true, "/search?limit=15&offset=85&q=x", ""},
+		{"/search?q=x&offset=9223372036854775807", 10, 10, 10, 10, false, "/search?limit=15&offset=0&q=x", ""},
+	}
+	for _, tt := range tests {
+		p, err := s.parsePage(httptest.NewRequest("GET", tt.url, nil), 15)
+		if err != nil {
+			t.Fatalf("parsePage(%q): %v", tt.url, err)
+		}
+		start, end := s.paginate(p, tt.n)
+		if start != tt.start || end != tt.end || p.Total != tt.total || p.Truncated != tt.truncated {
+			t.Errorf("%q, %v results: got [%v:%v] of %v (truncated %v), want [%v:%v] of %v (truncated %v)",
+				tt.url, tt.n, start, end, p.Total, p.Truncated, tt.start, tt.end, tt.total, tt.truncated)
+		}
+		if prev := p.PrevURL(); prev != tt.prev {
+			t.Errorf("%q: PrevURL() = %q, want %q", tt.url, prev, tt.prev)
+		}
+		if next := p.NextURL(); next != tt.next {
+			t.Errorf("%q: NextURL() = %q, want %q", tt.url, next, tt.next)
+		}
+	}
+}
+
+func TestPageBounds(t *testing.T) {
+	tests := []struct {
+		offset, limit, total int
+		start, end           int
+	}{
+		{0, 10, 0, 0, 0},
+		{0, 10, 25, 1, 10},
+		{20, 10, 25, 21, 25},
+		{1 << 62, 1 << 62, 25, 1<<62 + 1, 25},
+	}
+	for _, tt := range tests {
+		p := &resultPage{Offset: tt.offset, Limit: tt.limit, Total: tt.total}
+		if start, end := p.Start(), p.End(); start != tt.start || end != tt.end {
+			t.Errorf("page at %v of %v: got %v-%v, want %v-%v",
+				tt.offset, tt.total, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestParsePageInvalid(t *testing.T) {
+	s := &Server{maxResults: 100, maxPageSize: 20}
+	for _, url := range []string{"/search?limit=-1", "/search?offset=x"} {
+		if _, err := s.parsePage(httptest.NewRequest("GET", url, nil), 10); err == nil {
+			t.Errorf("parsePage(%q) succeeded", url)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+)
+
+// Number of values listed per facet.
+const maxFacetValues = 20
+
+// Search filter query parameters.
+const (
+	filterCategory      = "category"
+	filterTag           = "tag"
+	filterPublisher     = "publisher"
+	filterNotCategory   = "not_category"
+	filterNotTag        = "not_tag"
+	filterNotPublisher  = "not_publisher"
+	filterUpdatedAfter  = "updated_after"
+	filterUpdatedBefore = "updated_before"
+	filterField         = "field"
+)
+
+// Date format of the updated_after and updated_before parameters.
+const filterDateFormat = "2006-01-02"
+
+// searchFilter restricts search results by their metadata.
+//
+// A dataset matches if it has any of the given categories, any of the given
+// tags and any of the given publishers (attributions), none of the excluded
+// ones, and was updated on or after UpdatedAfter and before UpdatedBefore.
+// Empty fields do not restrict the results.
+//
+// If Fields is not empty, the query is only matched in the given metadata
+// fields, which does not restrict the results.
+type searchFilter struct {
+	Categories    []string `json:"category,omitempty"`
+	Tags          []string `json:"tag,omitempty"`
+	Publishers    []string `json:"publisher,omitempty"`
+	NotCategories []string `json:"not_category,omitempty"`
+	NotTags       []string `json:"not_tag,omitempty"`
+	NotPublishers []string `json:"not_publisher,omitempty"`
+	UpdatedAfter  string   `json:"updated_after,omitempty"`
+	UpdatedBefore string   `json:"updated_before,omitempty"`
+	Fields        []string `json:"field,omitempty"`
+}
+
+// parseSearchFilter parses the search filter query parameters in form.
+func parseSearchFilter(form url.Values) (*searchFilter, error) {
+	f := &searchFilter{
+		Categories:    nonEmpty(form[filterCategory]),
+		Tags:          nonEmpty(form[filterTag]),
+		Publishers:    nonEmpty(form[filterPublisher]),
+		NotCategories: nonEmpty(form[filterNotCategory]),
+		NotTags:       nonEmpty(form[filterNotTag]),
+		NotPublishers: nonEmpty(form[filterNotPublisher]),
+		UpdatedAfter:  form.Get(filterUpdatedAfter),
+		UpdatedBefore: form.Get(filterUpdatedBefore),
+		Fields:        nonEmpty(form[filterField]),
+	}
+	for _, field := range f.Fields {
+		if !isMetadataField(field) {
+			return nil, fmt.Errorf("invalid field %q: want one of %s",
+				field, strings.Join(database.MetadataFields, ", "))
+		}
+	}
+	for _, date := range []string{f.UpdatedAfter, f.UpdatedBefore} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse(filterDateFormat, date); err != nil {
+			return nil, fmt.Errorf("invalid date %q: want YYYY-MM-DD", date)
+		}
+	}
+	return f, nil
+}
+
+// parseSearchFilter parses the search filter query parameters in form.
+// Returns errNoFieldSearch if the filter has fields but the server has no
+// field indexes.
+func (s *Server) parseSearchFilter(form url.Values) (*searchFilter, error) {
+	f, err := parseSearchFilter(form)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Fields) > 0 && s.fieldIndexes == nil {
+		return nil, errNoFieldSearch
+	}
+	return f, nil
+}
+
+func nonEmpty(values []string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func isMetadataField(field string) bool {
+	for _, f := range database.MetadataFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// empty reports whether the filter matches every dataset.
+func (f *searchFilter) empty() bool {
+	for _, p := range f.params() {
+		if p.Name != filterField {
+			return false
+		}
+	}
+	return true
+}
+
+// filterParam is a search filter query parameter.
+type filterParam struct {
+	Name, Value string
+}
+
+// params returns the query parameters of the filter.
+func (f *searchFilter) params() []filterParam {
+	var params []filterParam
+	add := func(name string, values ...string) {
+		for _, v := range values {
+			if v != "" {
+				params = append(params, filterParam{name, v})
+			}
+		}
+	}
+	add(filterCategory, f.Categories...)
+	add(filterTag, f.Tags...)
+	add(filterPublisher, f.Publishers...)
+	add(filterNotCategory, f.NotCategories...)
+	add(filterNotTag, f.NotTags...)
+	add(filterNotPublisher, f.NotPublishers...)
+	add(filterUpdatedAfter, f.UpdatedAfter)
+	add(filterUpdatedBefore, f.UpdatedBefore)
+	add(filterField, f.Fields...)
+	return params
+}
+
+// Params returns the query parameters of the filter.
+// It is used by the search template to list the active filters.
+func (f *searchFilter) Params() []filterParam {
+	return f.params()
+}
+
+// URL returns the URL of the search for query with the filter and the
+// parameter name=value added.
+func (f *searchFilter) URL(query, name, value string) string {
+	v := f.values(query)
+	for _, x := range v[name] {
+		if x == value {
+			return "/search?" + v.Encode()
+		}
+	}
+	v.Add(name, value)
+	return "/search?" + v.Encode()
+}
+
+// RemoveURL returns the URL of the search for query with the filter without
+// the parameter name=value.
+func (f *searchFilter) RemoveURL(query, name, value string) string {
+	v := url.Values{"q": {query}}
+	for _, p := range f.params() {
+		if p.Name != name || p.Value != value {
+			v.Add(p.Name, p.Value)
+		}
+	}
+	return "/search?" + v.Encode()
+}
+
+// YearURL returns the URL of the search for query with the filter restricted
+// to datasets updated in the given year.
+func (f *searchFilter) YearURL(query, year string) string {
+	y, err := strconv.Atoi(year)
+	if err != nil {
+		return f.URL(query, filterUpdatedAfter, year)
+	}
+	v := f.values(query)
+	v.Set(filterUpdatedAfter, fmt.Sprintf("%04d-01-01", y))
+	v.Set(filterUpdatedBefore, fmt.Sprintf("%04d-01-01", y+1))
+	return "/search?" + v.Encode()
+}
+
+func (f *searchFilter) values(query string) url.Values {
+	v := url.Values{"q": {query}}
+	for _, p := range f.params() {
+		v.Add(p.Name, p.Value)
+	}
+	return v
+}
+
+// where returns an SQL expression over the columns of the metadata table that
+// is true for the datasets matching the filter, and its arguments.
+func (f *searchFilter) where() (string, []interface{}) {
+	conds := []string{"1"}
+	var args []interface{}
+
+	anyOf := func(negate bool, cond string, values []string, arg func(string) string) {
+		if len(values) == 0 {
+			return
+		}
+		alts := make([]string, len(values))
+		for i, v := range values {
+			alts[i] = cond
+			args = append(args, arg(v))
+		}
+		c := "(" + strings.Join(alts, " OR ") + ")"
+		if negate {
+			c = "NOT " + c
+		}
+		conds = append(conds, c)
+	}
+	// Categories and tags are comma-separated lists.
+	listCond := "instr(',' || %s || ',', ?) > 0"
+	listArg := func(v string) string { return "," + v + "," }
+	same := func(v string) string { return v }
+
+	anyOf(false, fmt.Sprintf(listCond, "categories"), f.Categories, listArg)
+	anyOf(false, fmt.Sprintf(listCond, "tags"), f.Tags, listArg)
+	anyOf(false, "attribution = ?", f.Publishers, same)
+	anyOf(true, fmt.Sprintf(listCond, "categories"), f.NotCategories, listArg)
+	anyOf(true, fmt.Sprintf(listCond, "tags"), f.NotTags, listArg)
+	anyOf(true, "attribution = ?", f.NotPublishers, same)
+
+	// updated_at is an ISO 8601 timestamp, so it can be compared with dates
+	// as a string.
+	if f.UpdatedAfter != "" {
+		conds = append(conds, "updated_at >= ?")
+		args = append(args, f.UpdatedAfter)
+	}
+	if f.UpdatedBefore != "" {
+		conds = append(conds, "updated_at < ?")
+		args = append(args, f.UpdatedBefore)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// filteredDatasets returns the set of IDs of the datasets matching f.
+func (s *Server) filteredDatasets(f *searchFilter) (map[string]bool, error) {
+	where, args := f.where()
+	rows, err := s.db.Query(`
+	SELECT dataset_id FROM metadata WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]bool)
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// facetCount is the number of search results with a facet value.
+type facetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// searchFacets counts the values of the metadata facets in search results.
+// Only the most frequent values of each facet are included.
+type searchFacets struct {
+	Categories []*facetCount `json:"categories"`
+	Tags       []*facetCount `json:"tags"`
+	Publishers []*facetCount `json:"publishers"`
+	// Years in which the datasets were last updated.
+	UpdatedYears []*facetCount `json:"updated_years"`
+}
+
+func countFacets(results []*searchResult) *searchFacets {
+	categories := make(map[string]int)
+	tags := make(map[string]int)
+	publishers := make(map[string]int)
+	years := make(map[string]int)
+
+	for _, res := range results {
+		for _, c := range res.Categories {
+			categories[c]++
+		}
+		for _, t := range res.Tags {
+			tags[t]++
+		}
+		if res.Attribution != "" {
+			publishers[res.Attribution]++
+		}
+		if len(res.UpdatedAt) >= 4 {
+			years[res.UpdatedAt[:4]]++
+		}
+	}
+	return &searchFacets{
+		Categories:   topFacetValues(categories),
+		Tags:         topFacetValues(tags),
+		Publishers:   topFacetValues(publishers),
+		UpdatedYears: topFacetValues(years),
+	}
+}
+
+// topFacetValues returns the maxFacetValues most frequent values, sorted by
+// decreasing count and then by value.
+func topFacetValues(counts map[string]int) []*facetCount {
+	facets := make([]*facetCount, 0, len(counts))
+	for v, n := range counts {
+		if v != "" {
+			facets = append(facets, &facetCount{v, n})
+		}
+	}
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+		return facets[i].Value < facets[j].Value
+	})
+	if len(facets) > maxFacetValues {
+		facets = facets[:maxFacetValues]
+	}
+	return facets
+}
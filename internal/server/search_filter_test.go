@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestFilteredDatasets(t *testing.T) {
+	db, err := database.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+	CREATE TABLE metadata (
+		dataset_id TEXT NOT NULL PRIMARY KEY,
+		attribution TEXT NOT NULL,
+		updated_at TEXT NOT NULL,
+		categories TEXT NOT NULL,
+		tags TEXT NOT NULL
+	);
+	INSERT INTO metadata VALUES
+		('a', 'NYC DOE', '2019-05-01T00:00:00.000Z', 'Education', 'schools,k-12'),
+		('b', 'NYPD', '2020-01-01T12:00:00.000Z', 'Public Safety', 'crime,schools'),
+		('c', 'NYC DOE', '2020-12-31T23:59:59.000Z', 'Education,Health', 'nutrition'),
+		('d', 'DOHMH', '2021-03-01T00:00:00.000Z', 'Health', '')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{db: db}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"a", "b", "c", "d"}},
+		{"category=Education", []string{"a", "c"}},
+		{"category=Educ", nil},
+		{"category=Education&category=Health", []string{"a", "c", "d"}},
+		{"category=Education&not_category=Health", []string{"a"}},
+		{"tag=schools", []string{"a", "b"}},
+		{"tag=schools&publisher=NYPD", []string{"b"}},
+		{"not_publisher=NYC+DOE&not_tag=crime", []string{"d"}},
+		{"updated_after=2020-01-01", []string{"b", "c", "d"}},
+		{"updated_after=2020-01-01&updated_before=2021-01-01", []string{"b", "c"}},
+		{"field=name&field=tags", []string{"a", "b", "c", "d"}},
+		{"field=description&tag=schools", []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		form, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := parseSearchFilter(form)
+		if err != nil {
+			t.Errorf("parseSearchFilter(%q): %v", tt.query, err)
+			continue
+		}
+		ids, err := s.filteredDatasets(f)
+		if err != nil {
+			t.Errorf("filteredDatasets(%q): %v", tt.query, err)
+			continue
+		}
+		var got []string
+		for id := range ids {
+			got = append(got, id)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("filteredDatasets(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseSearchFilterInvalidDate(t *testing.T) {
+	form := url.Values{filterUpdatedAfter: {"01/02/2020"}}
+	if _, err := parseSearchFilter(form); err == nil {
+		t.Error("parseSearchFilter accepted an invalid date")
+	}
+}
+
+func TestParseSearchFilterFields(t *testing.T) {
+	form := url.Values{filterField: {"name", "tags"}}
+	f, err := parseSearchFilter(form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f.Fields, []string{"name", "tags"}) {
+		t.Errorf("Fields = %v, want [name tags]", f.Fields)
+	}
+	if !f.empty() {
+		t.Error("filter with only fields is not empty")
+	}
+
+	form = url.Values{filterField: {"name; DROP TABLE metadata"}}
+	if _, err := parseSearchFilter(form); err == nil {
+		t.Error("parseSearchFilter accepted an invalid field")
+	}
+
+	s := &Server{}
+	if _, err := s.parseSearchFilter(url.Values{filterField: {"name"}}); err != errNoFieldSearch {
+		t.Errorf("parseSearchFilter without field indexes: got %v, want %v", err, errNoFieldSearch)
+	}
+}
@@ -13,27 +13,37 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/index"
 	nav "github.com/DataIntelligenceCrew/OpenDataLink/internal/navigation"
-	"github.com/ekzhu/go-fasttext"
-	"github.com/ekzhu/lshensemble"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
 )
 
 // Server serves the Open Data Link frontend.
 type Server struct {
 	devMode              bool
 	db                   *database.DB
-	ft                   *fasttext.FastText
+	embedder             wordemb.Embedder
 	metadataIndex        *index.MetadataIndex
+	fieldIndexes         map[string]*index.MetadataIndex
 	joinabilityThreshold float64
-	joinabilityIndex     *lshensemble.LshEnsemble
+	joinabilityIndex     *index.LshIndex
+	columnPairIndex      *index.LshIndex
 	mux                  sync.Mutex // Guards access to templates
 	templates            map[string]*template.Template
-	organization         *nav.TableGraph
+	organizations        *organizationCache
 	organizationConfig   *nav.Config
-	organizationGraphSVG []byte
+	organizeJobs         chan *organization
+	saveOrganizations    bool
+	fullTextSearch       bool // Whether the metadata_fts table exists
+	maxResults           int
+	maxPageSize          int
+	verifyResults        int
+	unionDefaults        *unionOptions
+	unionNameCandidates  int
+	unionFeatures        *featureCache
 }
 
 // Config is used to configure the server.
@@ -41,11 +51,58 @@ type Config struct {
 	// If DevMode is true, templates will not be cached.
 	DevMode              bool
 	DB                   *database.DB
-	FastText             *fasttext.FastText
 	MetadataIndex        *index.MetadataIndex
 	JoinabilityThreshold float64
-	JoinabilityIndex     *lshensemble.LshEnsemble
-	OrganizeConfig       *nav.Config
+	JoinabilityIndex     *index.LshIndex
+	// Embedding model of the metadata vectors, used to embed queries.
+	Embedder wordemb.Embedder
+	// Indexes of the metadata field vectors by field, used to match queries
+	// in specific fields. Field-targeted search is disabled if it is nil.
+	MetadataFieldIndexes map[string]*index.MetadataIndex
+	// Index of the column pairs used for composite key search.
+	// Composite key search is disabled if ColumnPairIndex is nil.
+	ColumnPairIndex *index.LshIndex
+	OrganizeConfig  *nav.Config
+	// Maximum number of organizations kept in memory.
+	// A default is used if OrganizationCacheSize is zero.
+	OrganizationCacheSize int
+	// Time after which an organization is removed from memory.
+	// A default is used if OrganizationTTL is zero.
+	OrganizationTTL time.Duration
+	// Number of goroutines building organizations in the background.
+	// A default is used if OrganizeWorkers is zero.
+	OrganizeWorkers int
+	// If SaveOrganizations is true, built organizations are saved in the
+	// organizations table and can be reloaded after they are removed from
+	// memory.
+	SaveOrganizations bool
+	// Maximum number of results ranked by a search. Only the best results can
+	// be paged through. A default is used if MaxResults is zero.
+	MaxResults int
+	// Maximum number of results in a page.
+	// A default is used if MaxPageSize is zero.
+	MaxPageSize int
+	// Number of joinability results verified by exact containment when
+	// requested. A default is used if VerifyResults is zero.
+	VerifyResults int
+	// Default unionable table search parameters, which can be overridden by
+	// request parameters. A default is used for each one that is zero.
+	//
+	// UnionColumnThreshold is the minimum containment of a query column in
+	// the columns that make a table share values with the query table, and
+	// UnionColumnFraction the minimum fraction of query columns that share
+	// values or names with a candidate table. Column pairs are aligned while
+	// the product of their scores is at least UnionScoreThreshold.
+	// UnionMetadataCandidates is the number of candidates with similar
+	// metadata, which are used by default if UnionByMetadata is true, and
+	// UnionNameCandidates the maximum number of candidates with the same
+	// column names.
+	UnionColumnThreshold    float64
+	UnionColumnFraction     float64
+	UnionScoreThreshold     float64
+	UnionMetadataCandidates int
+	UnionByMetadata         bool
+	UnionNameCandidates     int
 }
 
 // New creates a new Server with the given configuration.
@@ -54,16 +111,74 @@ func New(cfg *Config) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Server{
+	fullTextSearch, err := cfg.DB.HasTable("metadata_fts")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
 		devMode:              cfg.DevMode,
 		db:                   cfg.DB,
-		ft:                   cfg.FastText,
+		embedder:             cfg.Embedder,
 		templates:            templates,
 		metadataIndex:        cfg.MetadataIndex,
+		fieldIndexes:         cfg.MetadataFieldIndexes,
 		joinabilityThreshold: cfg.JoinabilityThreshold,
 		joinabilityIndex:     cfg.JoinabilityIndex,
-		organizationConfig:   cfg.OrganizeConfig,
-	}, nil
+		columnPairIndex:      cfg.ColumnPairIndex,
+		organizations: newOrganizationCache(
+			cfg.OrganizationCacheSize, cfg.OrganizationTTL),
+		organizationConfig:  cfg.OrganizeConfig,
+		organizeJobs:        make(chan *organization, organizeQueueSize),
+		saveOrganizations:   cfg.SaveOrganizations,
+		fullTextSearch:      fullTextSearch,
+		maxResults:          cfg.MaxResults,
+		maxPageSize:         cfg.MaxPageSize,
+		verifyResults:       cfg.VerifyResults,
+		unionNameCandidates: cfg.UnionNameCandidates,
+		unionFeatures:       newFeatureCache(unionFeatureCacheSize),
+	}
+	if s.maxResults <= 0 {
+		s.maxResults = defaultMaxResults
+	}
+	if s.maxPageSize <= 0 {
+		s.maxPageSize = defaultMaxPageSize
+	}
+	if s.verifyResults <= 0 {
+		s.verifyResults = defaultVerifyResults
+	}
+	s.unionDefaults = &unionOptions{
+		Candidates:         unionCandidatesValues,
+		ColumnThreshold:    cfg.UnionColumnThreshold,
+		ColumnFraction:     cfg.UnionColumnFraction,
+		ScoreThreshold:     cfg.UnionScoreThreshold,
+		MetadataCandidates: cfg.UnionMetadataCandidates,
+	}
+	if cfg.UnionByMetadata {
+		s.unionDefaults.Candidates = unionCandidatesAll
+	}
+	if s.unionDefaults.ColumnThreshold <= 0 {
+		s.unionDefaults.ColumnThreshold = defaultUnionColumnThreshold
+	}
+	if s.unionDefaults.ColumnFraction <= 0 {
+		s.unionDefaults.ColumnFraction = defaultUnionColumnFraction
+	}
+	if s.unionDefaults.ScoreThreshold <= 0 {
+		s.unionDefaults.ScoreThreshold = defaultUnionScoreThreshold
+	}
+	if s.unionDefaults.MetadataCandidates <= 0 {
+		s.unionDefaults.MetadataCandidates = defaultUnionMetadataCandidates
+	}
+	if s.unionNameCandidates <= 0 {
+		s.unionNameCandidates = defaultUnionNameCandidates
+	}
+	workers := cfg.OrganizeWorkers
+	if workers <= 0 {
+		workers = defaultOrganizeWorkers
+	}
+	for i := 0; i < workers; i++ {
+		go s.organizeWorker(s.organizeJobs)
+	}
+	return s, nil
 }
 
 // NewHandler returns an HTTP handler that handles requests to the server.
@@ -74,31 +189,102 @@ func (s *Server) NewHandler() http.Handler {
 	mux.HandleFunc("/search", s.handleSearch)
 	mux.HandleFunc("/similar-datasets", s.handleSimilarDatasets)
 	mux.HandleFunc("/joinable-columns", s.handleJoinableColumns)
+	mux.HandleFunc("/composite-joinable-columns", s.handleCompositeJoinableColumns)
 	mux.HandleFunc("/unionable-tables", s.handleUnionableTables)
+	mux.HandleFunc("/join-paths", s.handleJoinPaths)
+	mux.HandleFunc("/upload", s.handleUpload)
+	mux.HandleFunc("/joinable-values", s.handleJoinableValues)
 	mux.HandleFunc("/navigation/", s.handleNav)
 	mux.HandleFunc("/navigation-graph", s.handleNavGraph)
+	mux.HandleFunc("/organizations", s.handleOrganizations)
+	s.registerAPI(mux)
 
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("web/static"))))
 
 	return panicRecoveryHandler(loggingHandler(mux))
 }
 
+// handleNav serves a node of an organization.
+// The path is /navigation/{organization ID}/{node ID}; the root node is served
+// if the node ID is empty.
+// The build status is served if the organization has not been built yet.
 func (s *Server) handleNav(w http.ResponseWriter, req *http.Request) {
-	nodeID, err := strconv.ParseInt(req.URL.Path[len("/navigation/"):], 10, 64)
+	path := strings.SplitN(req.URL.Path[len("/navigation/"):], "/", 2)
+	org, err := s.organization(path[0])
 	if err != nil {
-		nodeID = s.organization.GetRootNode().ID()
+		s.serverError(w, err)
+		return
+	}
+	if org == nil {
+		http.NotFound(w, req)
+		return
+	}
+	g, _ := org.result()
+	if g == nil {
+		s.serveOrganizationStatus(w, org)
+		return
+	}
+	node := g.GetRootNode()
+	if len(path) == 2 && path[1] != "" {
+		nodeID, err := strconv.ParseInt(path[1], 10, 64)
+		if err != nil {
+			http.NotFound(w, req)
+			return
+		}
+		if node = g.Node(nodeID); node == nil {
+			http.NotFound(w, req)
+			return
+		}
 	}
 	s.servePage(w, "nav", &struct {
-		PageTitle string
-		Node      *nav.ServeableNode
-	}{"Navigation", nav.ToServeableNode(s.organization, s.organization.Node(nodeID))})
+		PageTitle      string
+		OrganizationID string
+		Node           *nav.ServeableNode
+	}{"Navigation", org.id, nav.ToServeableNode(g, node)})
 }
 
 func (s *Server) handleNavGraph(w http.ResponseWriter, req *http.Request) {
+	org, err := s.organization(req.FormValue("org"))
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	if org == nil {
+		http.NotFound(w, req)
+		return
+	}
+	g, svg := org.result()
+	if g == nil {
+		s.serveOrganizationStatus(w, org)
+		return
+	}
 	s.servePage(w, "navigation-graph", &struct {
 		PageTitle string
 		SVG       template.HTML
-	}{"Navigation Graph", template.HTML(s.organizationGraphSVG)})
+	}{"Navigation Graph", template.HTML(svg)})
+}
+
+// handleOrganizations lists the saved organizations.
+func (s *Server) handleOrganizations(w http.ResponseWriter, req *http.Request) {
+	var orgs []*database.Organization
+	if s.saveOrganizations {
+		var err error
+		if orgs, err = s.db.Organizations(); err != nil {
+			s.serverError(w, err)
+			return
+		}
+	}
+	s.servePage(w, "organizations", &struct {
+		PageTitle     string
+		Organizations []*database.Organization
+	}{"Saved navigations - Open Data Link", orgs})
+}
+
+func (s *Server) serveOrganizationStatus(w http.ResponseWriter, org *organization) {
+	s.servePage(w, "organization-status", &struct {
+		PageTitle string
+		*organizationStatus
+	}{"Building navigation - Open Data Link", org.status()})
 }
 
 func (s *Server) handleIndex(w http.ResponseWriter, req *http.Request) {
@@ -139,26 +325,61 @@ func (s *Server) handleDataset(w http.ResponseWriter, req *http.Request) {
 
 func (s *Server) handleSearch(w http.ResponseWriter, req *http.Request) {
 	query := req.FormValue("q")
-	s.organization = nil
-	results, err := s.keywordSearch(query)
+	page, err := s.parsePage(req, keywordSearchPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := s.parseSearchFilter(req.Form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	results, err := s.keywordSearch(query, filter)
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	start, end := s.paginate(page, len(results))
+	results = results[:page.Total]
+
+	orgID, err := s.buildOrganization(query, searchResultIDs(results))
 	if err != nil {
 		s.serverError(w, err)
 		return
 	}
+	var fields []string
+	if s.fieldIndexes != nil {
+		fields = database.MetadataFields
+	}
 	s.servePage(w, "search", &struct {
-		PageTitle string
-		Query     string
-		Results   []*database.Metadata
+		PageTitle      string
+		Query          string
+		Filter         *searchFilter
+		Facets         *searchFacets
+		Fields         []string
+		OrganizationID string
+		Page           *resultPage
+		Results        []*searchResult
 	}{
 		query + " - Open Data Link",
 		query,
-		results,
+		filter,
+		countFacets(results),
+		fields,
+		orgID,
+		page,
+		results[start:end],
 	})
 }
 
 func (s *Server) handleSimilarDatasets(w http.ResponseWriter, req *http.Request) {
 	queryID := req.FormValue("id")
-
+	page, err := s.parsePage(req, similarDatasetsPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	results, err := s.similarDatasets(queryID)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -168,6 +389,7 @@ func (s *Server) handleSimilarDatasets(w http.ResponseWriter, req *http.Request)
 		}
 		return
 	}
+	start, end := s.paginate(page, len(results))
 	datasetName, err := s.db.DatasetName(queryID)
 	if err != nil {
 		s.serverError(w, err)
@@ -177,16 +399,28 @@ func (s *Server) handleSimilarDatasets(w http.ResponseWriter, req *http.Request)
 		PageTitle   string
 		DatasetID   string
 		DatasetName string
-		Results     []*database.Metadata
+		Page        *resultPage
+		Results     []*searchResult
 	}{
 		"Similar datasets for " + datasetName + " - Open Data Link",
 		queryID,
 		datasetName,
-		results,
+		page,
+		results[start:end],
 	})
 }
 
 func (s *Server) handleJoinableColumns(w http.ResponseWriter, req *http.Request) {
+	page, err := s.parsePage(req, joinableColumnsPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := s.parseJoinabilityOptions(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	query, err := s.db.ColumnSketch(req.FormValue("id"))
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -196,35 +430,136 @@ func (s *Server) handleJoinableColumns(w http.ResponseWriter, req *http.Request)
 		}
 		return
 	}
-	results, err := s.joinableColumns(query)
+	results, verified, err := s.verifiedJoinableColumns(query, opts)
 	if err != nil {
 		s.serverError(w, err)
 		return
 	}
+	start, end := s.paginate(page, len(results))
+	results = results[:page.Total]
+	if err := s.setJoinabilityDatasetNames(results[start:end]); err != nil {
+		s.serverError(w, err)
+		return
+	}
 	datasetName, err := s.db.DatasetName(query.DatasetID)
 	if err != nil {
 		s.serverError(w, err)
 		return
 	}
+	orgID, err := s.buildOrganization(datasetName, joinabilityResultIDs(results))
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
 	s.servePage(w, "joinable-columns", &struct {
-		PageTitle   string
-		DatasetID   string
-		DatasetName string
-		ColumnName  string
-		Results     []*joinabilityResult
+		PageTitle      string
+		DatasetID      string
+		DatasetName    string
+		ColumnName     string
+		ColumnID       string
+		Options        *joinabilityOptions
+		Verified       bool
+		OrganizationID string
+		Page           *resultPage
+		Results        []*joinabilityResult
 	}{
 		"Joinable tables for " + datasetName + " - Open Data Link",
 		query.DatasetID,
 		datasetName,
 		query.ColumnName,
-		results,
+		query.ColumnID,
+		opts,
+		verified,
+		orgID,
+		page,
+		results[start:end],
+	})
+}
+
+// handleCompositeJoinableColumns serves the sets of columns joinable with a
+// composite key given by two or more id parameters.
+func (s *Server) handleCompositeJoinableColumns(w http.ResponseWriter, req *http.Request) {
+	page, err := s.parsePage(req, joinableColumnsPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	threshold, err := s.parseThreshold(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query, err := s.compositeQuery(req.URL.Query()["id"])
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			http.NotFound(w, req)
+		case errTooFewColumns, errMixedDatasets, errDuplicateColumn:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			s.serverError(w, err)
+		}
+		return
+	}
+	results, err := s.compositeJoinableColumns(query, threshold)
+	if err != nil {
+		if err == errNoPairIndex {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+		} else {
+			s.serverError(w, err)
+		}
+		return
+	}
+	start, end := s.paginate(page, len(results))
+	results = results[:page.Total]
+	if err := s.setCompositeJoinabilityDatasetNames(results[start:end]); err != nil {
+		s.serverError(w, err)
+		return
+	}
+	datasetName, err := s.db.DatasetName(query[0].DatasetID)
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	orgID, err := s.buildOrganization(datasetName, compositeJoinabilityResultIDs(results))
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	s.servePage(w, "composite-joinable-columns", &struct {
+		PageTitle      string
+		DatasetID      string
+		DatasetName    string
+		Query          []*database.ColumnSketch
+		Threshold      float64
+		OrganizationID string
+		Page           *resultPage
+		Results        []*compositeJoinabilityResult
+	}{
+		"Joinable tables for " + datasetName + " - Open Data Link",
+		query[0].DatasetID,
+		datasetName,
+		query,
+		threshold,
+		orgID,
+		page,
+		results[start:end],
 	})
 }
 
 func (s *Server) handleUnionableTables(w http.ResponseWriter, req *http.Request) {
 	queryID := req.FormValue("id")
-
-	results, err := s.unionableTables(queryID)
+	page, err := s.parsePage(req, unionableTablesPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := s.parseUnionOptions(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	results, err := s.unionableDatasets(queryID, opts)
 	if err != nil {
 		if err == errInvalidID {
 			http.NotFound(w, req)
@@ -233,6 +568,12 @@ func (s *Server) handleUnionableTables(w http.ResponseWriter, req *http.Request)
 		}
 		return
 	}
+	start, end := s.paginate(page, len(results))
+	results = results[start:end]
+	if err := s.setUnionabilityDatasetNames(results); err != nil {
+		s.serverError(w, err)
+		return
+	}
 	datasetName, err := s.db.DatasetName(queryID)
 	if err != nil {
 		s.serverError(w, err)
@@ -242,15 +583,195 @@ func (s *Server) handleUnionableTables(w http.ResponseWriter, req *http.Request)
 		PageTitle   string
 		DatasetID   string
 		DatasetName string
+		Options     *unionOptions
+		Page        *resultPage
 		Results     []*unionabilityResult
 	}{
 		"Unionable tables for " + datasetName + " - Open Data Link",
 		queryID,
 		datasetName,
+		opts,
+		page,
 		results,
 	})
 }
 
+// handleJoinPaths serves the join paths from the dataset given by the from
+// parameter to the dataset given by the to parameter.
+func (s *Server) handleJoinPaths(w http.ResponseWriter, req *http.Request) {
+	from, to := req.FormValue("from"), req.FormValue("to")
+	page, err := s.parsePage(req, joinPathsPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hops, err := parseHops(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	threshold, err := s.parseThreshold(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fromName, err := s.db.DatasetName(from)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, req)
+		} else {
+			s.serverError(w, err)
+		}
+		return
+	}
+	data := &struct {
+		PageTitle string
+		From      string
+		FromName  string
+		To        string
+		ToName    string
+		Hops      int
+		Threshold float64
+		Error     string
+		Page      *resultPage
+		Results   []*joinPath
+	}{
+		PageTitle: "Join paths from " + fromName + " - Open Data Link",
+		From:      from,
+		FromName:  fromName,
+		To:        to,
+		Hops:      hops,
+		Threshold: threshold,
+	}
+	if to == "" {
+		s.servePage(w, "join-paths", data)
+		return
+	}
+	if data.ToName, err = s.db.DatasetName(to); err != nil {
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			data.Error = "no such dataset: " + to
+			s.servePage(w, "join-paths", data)
+		} else {
+			s.serverError(w, err)
+		}
+		return
+	}
+	results, err := s.joinPaths(from, to, hops, threshold)
+	if err != nil {
+		switch err {
+		case errSameDataset:
+			w.WriteHeader(http.StatusBadRequest)
+			data.Error = err.Error()
+			s.servePage(w, "join-paths", data)
+		case errInvalidID:
+			http.NotFound(w, req)
+		default:
+			s.serverError(w, err)
+		}
+		return
+	}
+	start, end := s.paginate(page, len(results))
+	results = results[start:end]
+	if err := s.setJoinPathDatasetNames(results); err != nil {
+		s.serverError(w, err)
+		return
+	}
+	data.Page, data.Results = page, results
+	s.servePage(w, "join-paths", data)
+}
+
+// handleUpload serves the CSV upload form and, for POST requests, the tables
+// joinable and unionable with the uploaded table.
+func (s *Server) handleUpload(w http.ResponseWriter, req *http.Request) {
+	data := &struct {
+		PageTitle string
+		Error     string
+		Result    *uploadResult
+	}{PageTitle: "Search by table - Open Data Link"}
+
+	if req.Method == http.MethodPost {
+		page, err := s.parsePage(req, joinableColumnsPageSize)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts, err := s.parseUnionOptions(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ts, fileName, err := readUpload(w, req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			data.Error = err.Error()
+			s.servePage(w, "upload", data)
+			return
+		}
+		if data.Result, err = s.searchUpload(ts, fileName, page.Limit, opts); err != nil {
+			s.serverError(w, err)
+			return
+		}
+	}
+	s.servePage(w, "upload", data)
+}
+
+// handleJoinableValues serves the form for searching columns containing a
+// list of values and, for POST requests, the search results.
+func (s *Server) handleJoinableValues(w http.ResponseWriter, req *http.Request) {
+	data := &struct {
+		PageTitle string
+		Values    string
+		Options   *joinabilityOptions
+		Error     string
+		Page      *resultPage
+		Results   []*joinabilityResult
+	}{
+		PageTitle: "Search by values - Open Data Link",
+		Options: &joinabilityOptions{
+			Mode:      joinModeThreshold,
+			Threshold: s.joinabilityThreshold,
+			K:         defaultJoinTopK,
+		},
+	}
+	if req.Method != http.MethodPost {
+		s.servePage(w, "joinable-values", data)
+		return
+	}
+	page, err := s.parsePage(req, joinableColumnsPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	values, err := readValues(w, req)
+	if err == nil {
+		data.Values = strings.Join(values, "\n")
+		var opts *joinabilityOptions
+		if opts, err = s.parseJoinabilityOptions(req); err == nil {
+			data.Options = opts
+		}
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		data.Error = err.Error()
+		s.servePage(w, "joinable-values", data)
+		return
+	}
+	results, err := s.joinableValues(values, data.Options)
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	start, end := s.paginate(page, len(results))
+	results = results[start:end]
+	if err := s.setJoinabilityDatasetNames(results); err != nil {
+		s.serverError(w, err)
+		return
+	}
+	data.Page, data.Results = page, results
+	s.servePage(w, "joinable-values", data)
+}
+
 func (s *Server) serverError(w http.ResponseWriter, err error) {
 	log.Print(err)
 	if s.devMode {
@@ -296,9 +817,15 @@ func parseTemplates() (map[string]*template.Template, error) {
 		"search",
 		"similar-datasets",
 		"joinable-columns",
+		"composite-joinable-columns",
 		"unionable-tables",
+		"join-paths",
 		"nav",
 		"navigation-graph",
+		"organization-status",
+		"organizations",
+		"upload",
+		"joinable-values",
 	}
 	templates := make(map[string]*template.Template)
 
@@ -1,29 +1,32 @@
 package server
 
-import "github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
-
-// similarDatasets returns the metadata of the 20 datasets most similar to the
-// query.
-func (s *Server) similarDatasets(datasetID string) ([]*database.Metadata, error) {
+// similarDatasets returns the datasets most similar to the query, up to one
+// more than the maximum number of results so that truncation can be detected.
+func (s *Server) similarDatasets(datasetID string) ([]*searchResult, error) {
 	vec, err := s.db.MetadataVector(datasetID)
 	if err != nil {
 		return nil, err
 	}
-	ids, _, err := s.metadataIndex.Query(vec, 21)
+	// The query dataset is its own nearest neighbor.
+	ids, scores, err := s.metadataIndex.Query(vec, int64(s.maxResults+2))
 	if err != nil {
 		return nil, err
 	}
-	var results []*database.Metadata
+	var results []*searchResult
 
-	for _, id := range ids {
-		if id == datasetID {
+	for i, id := range ids {
+		if id == datasetID || len(results) > s.maxResults {
 			continue
 		}
 		meta, err := s.db.Metadata(id)
 		if err != nil {
 			return nil, err
 		}
-		results = append(results, meta)
+		results = append(results, &searchResult{
+			Metadata:   meta,
+			Score:      float64(scores[i]),
+			Similarity: scores[i],
+		})
 	}
 	return results, nil
 }
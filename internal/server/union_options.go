@@ -0,0 +1,99 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Unionable table candidate generators.
+const (
+	// Tables sharing values or column names with the query table.
+	unionCandidatesValues = "values"
+	// Tables with metadata embeddings similar to that of the query table.
+	unionCandidatesMetadata = "metadata"
+	// Candidates of both generators.
+	unionCandidatesAll = "all"
+)
+
+// Default unionable table search parameters.
+const (
+	defaultUnionColumnThreshold    = 0.5
+	defaultUnionColumnFraction     = 0.4
+	defaultUnionScoreThreshold     = 0.5
+	defaultUnionMetadataCandidates = 50
+	defaultUnionNameCandidates     = 100
+)
+
+var (
+	errInvalidUnionCandidates = fmt.Errorf("candidates must be %q, %q or %q",
+		unionCandidatesValues, unionCandidatesMetadata, unionCandidatesAll)
+	errInvalidMetadataCandidates = errors.New("metadata_candidates must be a positive integer")
+)
+
+// unionOptions are the parameters of a unionable table search.
+type unionOptions struct {
+	// Candidate generator.
+	Candidates string `json:"candidates"`
+	// Minimum containment of a query column in the columns that make a table
+	// share values with the query table.
+	ColumnThreshold float64 `json:"column_threshold"`
+	// Minimum fraction of the query columns that share values or names with
+	// the columns of a candidate table.
+	ColumnFraction float64 `json:"column_fraction"`
+	// Column pairs are aligned in order of score while the product of their
+	// scores is at least ScoreThreshold (the pair that brings it below is
+	// included).
+	ScoreThreshold float64 `json:"score_threshold"`
+	// Number of candidates of the metadata candidate generator.
+	MetadataCandidates int `json:"metadata_candidates"`
+}
+
+// parseUnionOptions parses the candidates, column_threshold, column_fraction,
+// score_threshold and metadata_candidates URL query parameters of req.
+// Missing parameters are set to the server defaults.
+func (s *Server) parseUnionOptions(req *http.Request) (*unionOptions, error) {
+	opts := *s.unionDefaults
+	// The parameters are read from the URL so that the body of POST requests
+	// is not consumed.
+	query := req.URL.Query()
+
+	if v := query.Get("candidates"); v != "" {
+		switch v {
+		case unionCandidatesValues, unionCandidatesMetadata, unionCandidatesAll:
+			opts.Candidates = v
+		default:
+			return nil, errInvalidUnionCandidates
+		}
+	}
+	for _, p := range []struct {
+		name string
+		v    *float64
+	}{
+		{"column_threshold", &opts.ColumnThreshold},
+		{"column_fraction", &opts.ColumnFraction},
+		{"score_threshold", &opts.ScoreThreshold},
+	} {
+		v := query.Get(p.name)
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(f > 0 && f <= 1) {
+			return nil, fmt.Errorf("%s must be a number in (0, 1]", p.name)
+		}
+		*p.v = f
+	}
+	if v := query.Get("metadata_candidates"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, errInvalidMetadataCandidates
+		}
+		if n > s.maxResults {
+			n = s.maxResults
+		}
+		opts.MetadataCandidates = n
+	}
+	return &opts, nil
+}
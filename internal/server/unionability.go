@@ -1,56 +1,339 @@
 package server
 
 import (
+	"database/sql"
 	"errors"
 	"sort"
+	"strings"
 
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
-	"github.com/ekzhu/lshensemble"
 )
 
 var errInvalidID = errors.New("unionableTables: invalid dataset ID")
 
+// Number of sample rows of each table in a union preview.
+const unionPreviewRows = 5
+
 type unionabilityResult struct {
-	DatasetID   string
-	DatasetName string
-	Alignment   float64
+	DatasetID   string  `json:"dataset_id"`
+	DatasetName string  `json:"dataset_name"`
+	Alignment   float64 `json:"alignment"`
+	// Aligned column pairs in the order of the query columns.
+	Columns []*columnAlignment `json:"columns"`
+	Preview *unionPreview      `json:"preview"`
+}
+
+// columnAlignment is a query column aligned with a column of a unionable
+// table.
+type columnAlignment struct {
+	*columnMapping
+	// Ensemble unionability score of the measures.
+	Score    float64        `json:"score"`
+	Measures *unionMeasures `json:"measures"`
+	query    *database.ColumnSketch
+	column   *database.ColumnSketch
+}
+
+func newColumnAlignment(query, column *database.ColumnSketch, measures *unionMeasures) *columnAlignment {
+	return &columnAlignment{
+		columnMapping: &columnMapping{
+			QueryColumnID:   query.ColumnID,
+			QueryColumnName: query.ColumnName,
+			ColumnID:        column.ColumnID,
+			ColumnName:      column.ColumnName,
+		},
+		Score:    measures.score(),
+		Measures: measures,
+		query:    query,
+		column:   column,
+	}
+}
+
+// unionPreview shows sample rows of the union of the aligned columns of two
+// tables.
+type unionPreview struct {
+	// Names of the aligned query columns.
+	Columns []string `json:"columns"`
+	// Sample rows of the query table.
+	QueryRows [][]string `json:"query_rows"`
+	// Sample rows of the unionable table.
+	Rows [][]string `json:"rows"`
 }
 
-func (s *Server) unionableTables(datasetID string) ([]*unionabilityResult, error) {
+// newUnionPreview builds a preview of the union of the aligned columns from
+// the samples of the columns.
+func newUnionPreview(columns []*columnAlignment) *unionPreview {
+	p := &unionPreview{Columns: make([]string, len(columns))}
+	for i, c := range columns {
+		p.Columns[i] = c.QueryColumnName
+	}
+	sampleRows := func(sample func(*columnAlignment) []string) [][]string {
+		rows := [][]string{}
+		for i := 0; i < unionPreviewRows; i++ {
+			row := make([]string, len(columns))
+			var ok bool
+			for j, c := range columns {
+				if s := sample(c); i < len(s) {
+					row[j], ok = s[i], true
+				}
+			}
+			if !ok {
+				break
+			}
+			rows = append(rows, row)
+		}
+		return rows
+	}
+	p.QueryRows = sampleRows(func(c *columnAlignment) []string { return c.query.Sample })
+	p.Rows = sampleRows(func(c *columnAlignment) []string { return c.column.Sample })
+	return p
+}
+
+// unionableDatasets returns the tables unionable with the dataset with the
+// given ID. See unionableTables.
+func (s *Server) unionableDatasets(datasetID string, opts *unionOptions) ([]*unionabilityResult, error) {
 	query, err := s.db.DatasetColumns(datasetID)
 	if err != nil {
 		return nil, err
 	} else if len(query) == 0 {
 		return nil, errInvalidID
 	}
-	candidates, err := s.unionCandidates(query)
+	return s.unionableTables(query, opts)
+}
+
+// unionableTables returns the tables unionable with the query table according
+// to opts, sorted by alignment. The dataset names of the results are not set.
+//
+// Depending on opts.Candidates, candidates share values or column names with
+// the query table, or have similar metadata. Their columns are aligned by an
+// ensemble of unionability measures; see unionMeasures. Candidates with no
+// aligned columns are not returned.
+//
+// The semantic measure, which needs the embeddings of the sample values of
+// the columns, is only used to align the best s.maxResults candidates by the
+// other measures again; the columns of the other candidates are aligned
+// without it.
+func (s *Server) unionableTables(query []*database.ColumnSketch, opts *unionOptions) ([]*unionabilityResult, error) {
+	var candidates []string
+
+	if opts.Candidates != unionCandidatesMetadata {
+		valueCandidates, err := s.unionCandidates(query, opts)
+		if err != nil {
+			return nil, err
+		}
+		nameCandidates, err := s.nameUnionCandidates(query, opts.ColumnFraction)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(valueCandidates, nameCandidates...)
+	}
+	if opts.Candidates != unionCandidatesValues {
+		metadataCandidates, err := s.metadataUnionCandidates(query, opts.MetadataCandidates)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, metadataCandidates...)
+	}
+	candidates = uniqueDatasetIDs(candidates)
+
+	queryFeatures, err := s.columnFeatures(query)
 	if err != nil {
 		return nil, err
 	}
+	if queryFeatures, err = s.valueEmbeddings(queryFeatures); err != nil {
+		return nil, err
+	}
 	results := make([]*unionabilityResult, 0, len(candidates))
 
 	for _, datasetID := range candidates {
-		candidate, err := s.db.DatasetColumns(datasetID)
+		candidate, err := s.datasetFeatures(datasetID, false)
 		if err != nil {
 			return nil, err
 		}
-		datasetName, err := s.db.DatasetName(datasetID)
-		if err != nil {
-			return nil, err
+		alignment, columns := unionabilityScore(queryFeatures, candidate, opts.ScoreThreshold)
+		if len(columns) == 0 {
+			continue
 		}
-		alignment := unionabilityScore(query, candidate)
 		results = append(results, &unionabilityResult{
-			datasetID, datasetName, alignment,
+			DatasetID: datasetID,
+			Alignment: alignment,
+			Columns:   columns,
 		})
 	}
-	sort.Slice(results, func(i, j int) bool {
+	sortUnionabilityResults(results)
+
+	if s.embedder != nil {
+		best := results
+		if len(best) > s.maxResults {
+			best = best[:s.maxResults]
+		}
+		for _, res := range best {
+			candidate, err := s.datasetFeatures(res.DatasetID, true)
+			if err != nil {
+				return nil, err
+			}
+			res.Alignment, res.Columns = unionabilityScore(queryFeatures, candidate, opts.ScoreThreshold)
+		}
+		sortUnionabilityResults(results)
+	}
+	for _, res := range results {
+		res.Preview = newUnionPreview(res.Columns)
+	}
+	return results, nil
+}
+
+func sortUnionabilityResults(results []*unionabilityResult) {
+	sort.SliceStable(results, func(i, j int) bool {
 		return results[i].Alignment > results[j].Alignment
 	})
+}
 
+// setUnionabilityDatasetNames sets the dataset names of the results.
+func (s *Server) setUnionabilityDatasetNames(results []*unionabilityResult) error {
+	for _, res := range results {
+		name, err := s.db.DatasetName(res.DatasetID)
+		if err != nil {
+			return err
+		}
+		res.DatasetName = name
+	}
+	return nil
+}
+
+// columnFeatures computes the unionability features of the columns, except
+// the embeddings of their sample values.
+func (s *Server) columnFeatures(cols []*database.ColumnSketch) ([]*columnFeatures, error) {
+	features := make([]*columnFeatures, len(cols))
+	for i, c := range cols {
+		var err error
+		if features[i], err = newColumnFeatures(c, s.embedder); err != nil {
+			return nil, err
+		}
+	}
+	return features, nil
+}
+
+// valueEmbeddings returns copies of the features with the embeddings of the
+// sample values of the columns.
+func (s *Server) valueEmbeddings(features []*columnFeatures) ([]*columnFeatures, error) {
+	result := make([]*columnFeatures, len(features))
+	for i, f := range features {
+		var err error
+		if result[i], err = f.withValueEmbedding(s.embedder); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// datasetFeatures returns the unionability features of the columns of the
+// dataset with the given ID, with the embeddings of their sample values if
+// semantic is true. The features are cached until the indexes are updated.
+func (s *Server) datasetFeatures(datasetID string, semantic bool) ([]*columnFeatures, error) {
+	if features, ok := s.unionFeatures.get(datasetID, semantic); ok {
+		return features, nil
+	}
+	features, ok := s.unionFeatures.get(datasetID, false)
+	if !ok {
+		cols, err := s.db.DatasetColumns(datasetID)
+		if err != nil {
+			return nil, err
+		}
+		if features, err = s.columnFeatures(cols); err != nil {
+			return nil, err
+		}
+	}
+	if semantic {
+		var err error
+		if features, err = s.valueEmbeddings(features); err != nil {
+			return nil, err
+		}
+	}
+	s.unionFeatures.add(datasetID, features, semantic)
+	return features, nil
+}
+
+// nameUnionCandidates returns the IDs of the datasets that have columns with
+// the names of at least the given fraction of the columns of the table,
+// ignoring case. At most s.unionNameCandidates datasets are returned, those
+// sharing the most names first.
+// Unlike the candidates from unionCandidates, they need not share values with
+// the table, e.g. tables of different years or cities with the same schema.
+func (s *Server) nameUnionCandidates(table []*database.ColumnSketch, fraction float64) ([]string, error) {
+	names := make(map[string]bool)
+	var args []interface{}
+	for _, c := range table {
+		if name := strings.ToLower(c.ColumnName); name != "" && !names[name] {
+			names[name] = true
+			args = append(args, name)
+		}
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
+	rows, err := s.db.Query(`
+	SELECT dataset_id
+	FROM column_sketches
+	WHERE lower(column_name) IN (?`+strings.Repeat(", ?", len(args)-1)+`)
+	AND dataset_id != ?
+	GROUP BY dataset_id
+	HAVING COUNT(DISTINCT lower(column_name)) >= ?
+	ORDER BY COUNT(DISTINCT lower(column_name)) DESC, dataset_id
+	LIMIT ?`,
+		append(args, table[0].DatasetID, fraction*float64(len(table)),
+			s.unionNameCandidates)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		results = append(results, id)
+	}
+	return results, rows.Err()
+}
+
+// metadataUnionCandidates returns the IDs of the k datasets whose metadata
+// embeddings are the most similar to that of the table. Tables without
+// metadata, such as uploaded tables, are represented by the embedding of their
+// column names.
+func (s *Server) metadataUnionCandidates(table []*database.ColumnSketch, k int) ([]string, error) {
+	datasetID := table[0].DatasetID
+	vec, err := s.db.MetadataVector(datasetID)
+	if err == sql.ErrNoRows {
+		names := make([]string, len(table))
+		for i, c := range table {
+			names[i] = c.ColumnName
+		}
+		if vec, err = embedding(s.embedder, names); vec == nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids, _, err := s.metadataIndex.Query(vec, int64(k+1))
+	if err != nil {
+		return nil, err
+	}
+	var results []string
+	for _, id := range ids {
+		if id != datasetID && len(results) < k {
+			results = append(results, id)
+		}
+	}
 	return results, nil
 }
 
-func (s *Server) unionCandidates(table []*database.ColumnSketch) ([]string, error) {
+// unionCandidates returns the IDs of the datasets that have columns in which
+// at least opts.ColumnFraction of the columns of the table are contained with
+// at least opts.ColumnThreshold.
+func (s *Server) unionCandidates(table []*database.ColumnSketch, opts *unionOptions) ([]string, error) {
 	datasetID := table[0].DatasetID
 	// Maps dataset IDs to number of joinability query results they appear in.
 	joinabilityResults := make(map[string]int)
@@ -63,7 +346,8 @@ func (s *Server) unionCandidates(table []*database.ColumnSketch) ([]string, erro
 			continue
 		}
 		done := make(chan struct{})
-		results := s.joinabilityIndex.Query(c.Minhash, c.DistinctCount, 0.5, done)
+		results := s.joinabilityIndex.Query(
+			c.Minhash, c.DistinctCount, opts.ColumnThreshold, done)
 
 		// Used to avoid counting the same dataset multiple times for one query.
 		added := make(map[string]bool)
@@ -85,7 +369,7 @@ func (s *Server) unionCandidates(table []*database.ColumnSketch) ([]string, erro
 
 	var results []string
 	for dataset, count := range joinabilityResults {
-		if float64(count)/float64(len(table)) >= 0.4 {
+		if float64(count)/float64(len(table)) >= opts.ColumnFraction {
 			results = append(results, dataset)
 		}
 	}
@@ -93,11 +377,14 @@ func (s *Server) unionCandidates(table []*database.ColumnSketch) ([]string, erro
 }
 
 // unionabilityScore returns a score between 0 and 1 that represents the
-// unionability of the candidate table with the query table.
-// Roughly, it is the fraction of candidate columns that are unionable with a
-// query column.
-func unionabilityScore(query, candidate []*database.ColumnSketch) float64 {
-	var small, big []*database.ColumnSketch
+// unionability of the candidate table with the query table, and the aligned
+// column pairs in the order of the query columns.
+// Columns are matched greedily by their ensemble unionability score, and the
+// best matches are aligned while the product of their scores is at least the
+// threshold. Roughly, the score is the fraction of candidate columns that are
+// unionable with a query column.
+func unionabilityScore(query, candidate []*columnFeatures, threshold float64) (float64, []*columnAlignment) {
+	var small, big []*columnFeatures
 	var qsmall bool
 
 	if len(candidate) < len(query) {
@@ -106,44 +393,62 @@ func unionabilityScore(query, candidate []*database.ColumnSketch) float64 {
 		small, big = query, candidate
 		qsmall = true
 	}
-	var scores []float64
-	matched := make(map[*database.ColumnSketch]bool)
+	var pairs []*columnAlignment
+	matched := make(map[*columnFeatures]bool)
 
 	for _, c1 := range small {
-		var best *database.ColumnSketch
-		var bestCont float64
+		var best *columnFeatures
+		var bestMeasures *unionMeasures
+		var bestScore float64
 
 		for _, c2 := range big {
 			if matched[c2] {
 				continue
 			}
-			var q, x *database.ColumnSketch
+			var q, x *columnFeatures
 			if qsmall {
 				q, x = c1, c2
 			} else {
 				q, x = c2, c1
 			}
-			cont := lshensemble.Containment(
-				q.Minhash, x.Minhash, q.DistinctCount, x.DistinctCount)
-			if cont > bestCont {
-				best, bestCont = c2, cont
+			m := measureUnionability(q, x)
+			if score := m.score(); score > bestScore {
+				best, bestMeasures, bestScore = c2, m, score
 			}
 		}
 		if best != nil {
 			matched[best] = true
-			scores = append(scores, bestCont)
+			if qsmall {
+				pairs = append(pairs, newColumnAlignment(
+					c1.ColumnSketch, best.ColumnSketch, bestMeasures))
+			} else {
+				pairs = append(pairs, newColumnAlignment(
+					best.ColumnSketch, c1.ColumnSketch, bestMeasures))
+			}
 		}
 	}
-	sort.Float64s(scores)
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].Score > pairs[j].Score
+	})
 	score := float64(1)
 	alignment := 0
 
-	for i := len(scores) - 1; i >= 0; i-- {
-		if score < 0.5 {
+	for _, p := range pairs {
+		if score < threshold {
 			break
 		}
-		score *= scores[i]
+		score *= p.Score
 		alignment++
 	}
-	return float64(alignment) / float64(len(query))
+	aligned := pairs[:alignment]
+
+	// Maps query columns to their indexes.
+	queryIndex := make(map[*database.ColumnSketch]int)
+	for i, c := range query {
+		queryIndex[c.ColumnSketch] = i
+	}
+	sort.Slice(aligned, func(i, j int) bool {
+		return queryIndex[aligned[i].query] < queryIndex[aligned[j].query]
+	})
+	return float64(alignment) / float64(len(query)), aligned
 }
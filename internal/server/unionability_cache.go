@@ -0,0 +1,83 @@
+package server
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Maximum number of datasets whose column features are cached.
+const unionFeatureCacheSize = 1000
+
+// featureCache is a bounded cache of the unionability features of the columns
+// of datasets, keyed by dataset ID. The least recently used datasets are
+// evicted when the cache is full.
+// It is safe for concurrent use.
+type featureCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	// Elements are *featureCacheEntry, most recently used first.
+	lru *list.List
+}
+
+type featureCacheEntry struct {
+	datasetID string
+	features  []*columnFeatures
+	// Whether the features include the embeddings of the sample values.
+	semantic bool
+}
+
+func newFeatureCache(size int) *featureCache {
+	return &featureCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// get returns the features of the columns of the dataset with the given ID.
+// If semantic is true, only features that include the embeddings of the
+// sample values are returned. Returns false if there are none.
+func (c *featureCache) get(datasetID string, semantic bool) ([]*columnFeatures, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[datasetID]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*featureCacheEntry)
+	if semantic && !entry.semantic {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return entry.features, true
+}
+
+// add adds the features of the columns of the dataset with the given ID,
+// replacing those cached. The features must not be modified afterwards.
+func (c *featureCache) add(datasetID string, features []*columnFeatures, semantic bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &featureCacheEntry{datasetID, features, semantic}
+	if e, ok := c.entries[datasetID]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	for c.lru.Len() >= c.size {
+		oldest := c.lru.Back()
+		delete(c.entries, oldest.Value.(*featureCacheEntry).datasetID)
+		c.lru.Remove(oldest)
+	}
+	c.entries[datasetID] = c.lru.PushFront(entry)
+}
+
+// clear removes all the features from the cache.
+func (c *featureCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
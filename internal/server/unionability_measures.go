@@ -0,0 +1,174 @@
+package server
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/wordemb"
+	"github.com/ekzhu/lshensemble"
+)
+
+// Column value types inferred from samples.
+const (
+	typeEmpty   = "empty"
+	typeBoolean = "boolean"
+	typeInteger = "integer"
+	typeNumber  = "number"
+	typeDate    = "date"
+	typeText    = "text"
+)
+
+// Matches dates such as 2020-01-31, 2020-01-31T00:00:00.000 and 01/31/2020.
+var dateRe = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}([T ].*)?|\d{1,2}/\d{1,2}/\d{4}( .*)?)$`)
+
+// columnFeatures holds the features of a column used to measure its
+// unionability with other columns.
+type columnFeatures struct {
+	*database.ColumnSketch
+	// Type of the sample values.
+	typ string
+	// Embeddings of the column name and of the sample values, nil if there
+	// is none.
+	nameVec  []float32
+	valueVec []float32
+}
+
+// newColumnFeatures computes the features of a column, except the embedding
+// of the sample values; see withValueEmbedding. If emb is nil, the embeddings
+// are not computed.
+func newColumnFeatures(c *database.ColumnSketch, emb wordemb.Embedder) (*columnFeatures, error) {
+	f := &columnFeatures{ColumnSketch: c, typ: sampleType(c.Sample)}
+	if emb == nil {
+		return f, nil
+	}
+	var err error
+	if f.nameVec, err = embedding(emb, []string{c.ColumnName}); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// withValueEmbedding returns a copy of f with the embedding of the sample
+// values, which the semantic measure compares. If emb is nil, the embedding
+// is not computed.
+func (f *columnFeatures) withValueEmbedding(emb wordemb.Embedder) (*columnFeatures, error) {
+	g := *f
+	// Only text values have meaningful word embeddings.
+	if emb != nil && g.typ == typeText {
+		var err error
+		if g.valueVec, err = embedding(emb, g.Sample); err != nil {
+			return nil, err
+		}
+	}
+	return &g, nil
+}
+
+// embedding returns the embedding of the text, or nil if none of the words
+// have an embedding.
+func embedding(emb wordemb.Embedder, text []string) ([]float32, error) {
+	vec, err := emb.Embed(text)
+	if err == wordemb.ErrNoEmb {
+		return nil, nil
+	}
+	return vec, err
+}
+
+// sampleType infers the type of the values of a column from its sample.
+// Empty values are ignored.
+func sampleType(sample []string) string {
+	typ := typeEmpty
+	for _, v := range sample {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		var t string
+		if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+			t = typeInteger
+		} else if _, err := strconv.ParseFloat(v, 64); err == nil {
+			t = typeNumber
+		} else if dateRe.MatchString(v) {
+			t = typeDate
+		} else if _, err := strconv.ParseBool(v); err == nil {
+			t = typeBoolean
+		} else {
+			return typeText
+		}
+		switch {
+		case typ == typeEmpty:
+			typ = t
+		case typ == t:
+		case isNumeric(typ) && isNumeric(t):
+			typ = typeNumber
+		default:
+			return typeText
+		}
+	}
+	return typ
+}
+
+func isNumeric(typ string) bool {
+	return typ == typeInteger || typ == typeNumber
+}
+
+// typeCompatibility returns 1 if values of the types can be unioned, 0.5 for
+// integers and other numbers and 0 otherwise.
+// Columns without values are compatible with any column.
+func typeCompatibility(a, b string) float64 {
+	switch {
+	case a == b || a == typeEmpty || b == typeEmpty:
+		return 1
+	case isNumeric(a) && isNumeric(b):
+		return 0.5
+	default:
+		return 0
+	}
+}
+
+// cosine returns the cosine similarity of two unit vectors, or 0 if either is
+// nil or they are dissimilar.
+func cosine(a, b []float32) float64 {
+	if a == nil || b == nil {
+		return 0
+	}
+	return math.Max(0, float64(vec32.Dot(a, b)))
+}
+
+// unionMeasures are the measures of the unionability of a query column with
+// a column of another table.
+type unionMeasures struct {
+	// Estimated containment of the query column in the column.
+	Value float64 `json:"value"`
+	// Similarity of the embeddings of the column names.
+	Name float64 `json:"name"`
+	// Similarity of the embeddings of the sample values of text columns.
+	Semantic float64 `json:"semantic"`
+	// Compatibility of the types of the values.
+	Type float64 `json:"type"`
+}
+
+// measureUnionability measures the unionability of query column q with
+// column x.
+func measureUnionability(q, x *columnFeatures) *unionMeasures {
+	m := &unionMeasures{
+		Name:     cosine(q.nameVec, x.nameVec),
+		Semantic: cosine(q.valueVec, x.valueVec),
+		Type:     typeCompatibility(q.typ, x.typ),
+	}
+	if q.DistinctCount > 0 {
+		m.Value = lshensemble.Containment(
+			q.Minhash, x.Minhash, q.DistinctCount, x.DistinctCount)
+	}
+	return m
+}
+
+// score combines the measures into an ensemble score between 0 and 1.
+// As in Table Union Search, columns are unionable if they share values, or
+// have similar names or values even if they share none; the best of these
+// measures is scaled by the type compatibility.
+func (m *unionMeasures) score() float64 {
+	return m.Type * math.Max(m.Value, math.Max(m.Name, m.Semantic))
+}
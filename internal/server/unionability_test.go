@@ -0,0 +1,214 @@
+package server
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// features returns the unionability features of the columns without
+// embeddings.
+func features(cols []*database.ColumnSketch) []*columnFeatures {
+	f := make([]*columnFeatures, len(cols))
+	for i, c := range cols {
+		f[i], _ = newColumnFeatures(c, nil)
+	}
+	return f
+}
+
+func TestUnionabilityScore(t *testing.T) {
+	var zips, names []string
+	for i := 0; i < 50; i++ {
+		zips = append(zips, fmt.Sprint(10000+i))
+		names = append(names, fmt.Sprint("name ", i))
+	}
+	query := []*database.ColumnSketch{
+		sketchColumn("q-0", "name", names...),
+		sketchColumn("q-1", "zip", zips...),
+		sketchColumn("q-2", "notes", "a", "b"),
+	}
+	candidate := []*database.ColumnSketch{
+		sketchColumn("c-0", "zipcode", zips...),
+		sketchColumn("c-1", "facility", names...),
+	}
+	alignment, columns := unionabilityScore(features(query), features(candidate), 0.5)
+	if want := 2.0 / 3; alignment != want {
+		t.Errorf("alignment = %v, want %v", alignment, want)
+	}
+	var got [][2]string
+	for _, c := range columns {
+		got = append(got, [2]string{c.QueryColumnID, c.ColumnID})
+	}
+	if want := [][2]string{{"q-0", "c-1"}, {"q-1", "c-0"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("aligned columns %v, want %v", got, want)
+	}
+
+	p := newUnionPreview(columns)
+	if want := []string{"name", "zip"}; !reflect.DeepEqual(p.Columns, want) {
+		t.Errorf("preview columns %v, want %v", p.Columns, want)
+	}
+	if len(p.QueryRows) != unionPreviewRows || len(p.Rows) != unionPreviewRows {
+		t.Fatalf("got %v query rows and %v rows, want %v", len(p.QueryRows), len(p.Rows), unionPreviewRows)
+	}
+	if want := []string{"name 0", "10000"}; !reflect.DeepEqual(p.Rows[0], want) {
+		t.Errorf("first row %v, want %v", p.Rows[0], want)
+	}
+}
+
+func TestSampleType(t *testing.T) {
+	tests := []struct {
+		sample []string
+		want   string
+	}{
+		{nil, typeEmpty},
+		{[]string{"", " "}, typeEmpty},
+		{[]string{"1", "", "-20"}, typeInteger},
+		{[]string{"1", "2.5"}, typeNumber},
+		{[]string{"2020-01-31T00:00:00.000", "01/31/2020"}, typeDate},
+		{[]string{"true", "False"}, typeBoolean},
+		{[]string{"1", "Bronx"}, typeText},
+		{[]string{"2020-01-31", "1"}, typeText},
+	}
+	for _, tt := range tests {
+		if got := sampleType(tt.sample); got != tt.want {
+			t.Errorf("sampleType(%q) = %v, want %v", tt.sample, got, tt.want)
+		}
+	}
+}
+
+func TestUnionabilityByType(t *testing.T) {
+	// The columns share no values, but the names are the same.
+	q := sketchColumn("q-0", "year", "2019", "2020")
+	x := sketchColumn("c-0", "year", "2010", "2011")
+	y := sketchColumn("c-1", "year", "a", "b")
+	f := features([]*database.ColumnSketch{q, x, y})
+	fq, fx, fy := f[0], f[1], f[2]
+	// Embeddings are not computed without fastText, so set equal name
+	// embeddings.
+	fq.nameVec, fx.nameVec, fy.nameVec = []float32{1, 0}, []float32{1, 0}, []float32{1, 0}
+
+	if m := measureUnionability(fq, fx); m.Value > 0.1 || m.Name != 1 || m.score() != 1 {
+		t.Errorf("same name and type: got %+v, score %v", m, m.score())
+	}
+	if m := measureUnionability(fq, fy); m.Type != 0 || m.score() != 0 {
+		t.Errorf("incompatible types: got %+v, score %v", m, m.score())
+	}
+}
+
+func TestNameUnionCandidates(t *testing.T) {
+	db, err := database.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+	CREATE TABLE column_sketches (
+		column_id TEXT NOT NULL PRIMARY KEY,
+		dataset_id TEXT NOT NULL,
+		column_name TEXT NOT NULL
+	);
+	INSERT INTO column_sketches VALUES
+		('a-0', 'a', 'Year'), ('a-1', 'a', 'Borough'), ('a-2', 'a', 'Count'),
+		('b-0', 'b', 'YEAR'), ('b-1', 'b', 'borough'), ('b-2', 'b', 'Total'),
+		('c-0', 'c', 'year'), ('c-1', 'c', 'year2'), ('c-2', 'c', 'Zip')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{db: db, unionNameCandidates: 2}
+	query := []*database.ColumnSketch{
+		{DatasetID: "a", ColumnName: "Year"},
+		{DatasetID: "a", ColumnName: "Borough"},
+		{DatasetID: "a", ColumnName: "Count"},
+	}
+	for _, tt := range []struct {
+		fraction float64
+		want     []string
+	}{
+		{0.4, []string{"b"}},
+		{0.3, []string{"b", "c"}},
+		{1, nil},
+	} {
+		got, err := s.nameUnionCandidates(query, tt.fraction)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("fraction %v: got candidates %v, want %v", tt.fraction, got, tt.want)
+		}
+	}
+	// The candidates sharing the most names are kept.
+	s.unionNameCandidates = 1
+	if got, err := s.nameUnionCandidates(query, 0.3); err != nil || !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("got candidates %v, %v; want [b]", got, err)
+	}
+}
+
+func TestParseUnionOptions(t *testing.T) {
+	s := &Server{
+		maxResults: 100,
+		unionDefaults: &unionOptions{
+			Candidates:         unionCandidatesValues,
+			ColumnThreshold:    0.5,
+			ColumnFraction:     0.4,
+			ScoreThreshold:     0.5,
+			MetadataCandidates: 50,
+		},
+	}
+	req := httptest.NewRequest("GET", "/unionable-tables?candidates=all&column_threshold=0.3&score_threshold=1&metadata_candidates=500", nil)
+	opts, err := s.parseUnionOptions(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &unionOptions{
+		Candidates:         unionCandidatesAll,
+		ColumnThreshold:    0.3,
+		ColumnFraction:     0.4,
+		ScoreThreshold:     1,
+		MetadataCandidates: 100,
+	}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("got %+v, want %+v", opts, want)
+	}
+	if s.unionDefaults.ColumnThreshold != 0.5 {
+		t.Error("defaults modified")
+	}
+	for _, q := range []string{"candidates=names", "column_fraction=0", "score_threshold=x", "metadata_candidates=-1"} {
+		req := httptest.NewRequest("GET", "/unionable-tables?"+q, nil)
+		if _, err := s.parseUnionOptions(req); err == nil {
+			t.Errorf("%q: no error", q)
+		}
+	}
+}
+
+func TestFeatureCache(t *testing.T) {
+	c := newFeatureCache(2)
+	a := features([]*database.ColumnSketch{sketchColumn("a-0", "x", "1")})
+	c.add("a", a, false)
+	c.add("b", nil, true)
+	if _, ok := c.get("a", true); ok {
+		t.Error("features without value embeddings returned as semantic")
+	}
+	if got, ok := c.get("a", false); !ok || !reflect.DeepEqual(got, a) {
+		t.Errorf("got %v, %v; want features of a", got, ok)
+	}
+	// b is the least recently used.
+	c.add("c", nil, false)
+	if _, ok := c.get("b", false); ok {
+		t.Error("least recently used dataset not evicted")
+	}
+	if _, ok := c.get("a", false); !ok {
+		t.Error("recently used dataset evicted")
+	}
+	c.clear()
+	if _, ok := c.get("a", false); ok {
+		t.Error("features returned after clear")
+	}
+}
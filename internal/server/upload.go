@@ -0,0 +1,136 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/sketch"
+)
+
+const (
+	// Maximum size of an uploaded CSV file.
+	maxUploadSize = 32 << 20
+	// Dataset ID of uploaded tables. It is not a Socrata four-by-four, so it
+	// does not clash with the datasets in the database.
+	uploadDatasetID = "upload"
+)
+
+var (
+	errNoUpload    = errors.New("no CSV file uploaded")
+	errEmptyUpload = errors.New("uploaded CSV file is empty")
+)
+
+// uploadResult holds the results of a search for tables joinable and
+// unionable with an uploaded table.
+type uploadResult struct {
+	FileName string          `json:"file_name"`
+	Columns  []*uploadColumn `json:"columns"`
+	// Number of unionable tables.
+	UnionableTotal int                   `json:"unionable_total"`
+	Unionable      []*unionabilityResult `json:"unionable"`
+	UnionOptions   *unionOptions         `json:"union_options"`
+}
+
+// uploadColumn holds the columns joinable with a column of an uploaded table.
+type uploadColumn struct {
+	ColumnName    string   `json:"column_name"`
+	DistinctCount int      `json:"distinct_count"`
+	Sample        []string `json:"sample"`
+	// Number of joinable columns.
+	JoinableTotal int                  `json:"joinable_total"`
+	Joinable      []*joinabilityResult `json:"joinable"`
+}
+
+// readUpload sketches the CSV file uploaded with req, either as the file form
+// field of a multipart form or as the request body.
+// Returns the sketch and the file name.
+// Errors are caused by the request and should be reported as such.
+func readUpload(w http.ResponseWriter, req *http.Request) (*sketch.TableSketch, string, error) {
+	req.Body = http.MaxBytesReader(w, req.Body, maxUploadSize)
+
+	var r io.Reader
+	var fileName string
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		mr, err := req.MultipartReader()
+		if err != nil {
+			return nil, "", err
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil, "", errNoUpload
+			} else if err != nil {
+				return nil, "", err
+			}
+			if part.FormName() == "file" {
+				r, fileName = part, part.FileName()
+				break
+			}
+		}
+	} else {
+		r = req.Body
+	}
+	ts, err := sketch.SketchCSV(r, uploadDatasetID)
+	if err != nil {
+		return nil, "", err
+	}
+	if ts == nil {
+		return nil, "", errEmptyUpload
+	}
+	return ts, fileName, nil
+}
+
+// searchUpload finds the columns joinable with each column of the uploaded
+// table and the tables unionable with it according to opts. At most limit
+// results of each search are returned.
+func (s *Server) searchUpload(ts *sketch.TableSketch, fileName string, limit int, opts *unionOptions) (*uploadResult, error) {
+	cols := ts.Columns()
+	// Empty results are encoded as [], not null.
+	res := &uploadResult{
+		FileName:     fileName,
+		Unionable:    []*unionabilityResult{},
+		UnionOptions: opts,
+	}
+
+	for _, c := range cols {
+		col := &uploadColumn{
+			ColumnName:    c.ColumnName,
+			DistinctCount: c.DistinctCount,
+			Sample:        c.Sample,
+			Joinable:      []*joinabilityResult{},
+		}
+		res.Columns = append(res.Columns, col)
+		if c.DistinctCount == 0 {
+			continue
+		}
+		joinable, err := s.joinableColumns(c)
+		if err != nil {
+			return nil, err
+		}
+		col.JoinableTotal = len(joinable)
+		if len(joinable) > limit {
+			joinable = joinable[:limit]
+		}
+		if err := s.setJoinabilityDatasetNames(joinable); err != nil {
+			return nil, err
+		}
+		col.Joinable = append(col.Joinable, joinable...)
+	}
+	unionable, err := s.unionableTables(cols, opts)
+	if err != nil {
+		return nil, err
+	}
+	res.UnionableTotal = len(unionable)
+	if len(unionable) > limit {
+		unionable = unionable[:limit]
+	}
+	if err := s.setUnionabilityDatasetNames(unionable); err != nil {
+		return nil, err
+	}
+	res.Unionable = append(res.Unionable, unionable...)
+	return res, nil
+}
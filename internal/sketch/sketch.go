@@ -0,0 +1,316 @@
+// Package sketch computes the column sketches used for joinable and unionable
+// table search.
+package sketch
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/database"
+	"github.com/axiomhq/hyperloglog"
+	"github.com/ekzhu/lshensemble"
+)
+
+const (
+	// Minhash parameters
+	MinhashSeed = 42
+	MinhashSize = 256
+	// Number of sample data values
+	SampleSize = 20
+	// Maximum number of columns of a table whose pairs are sketched
+	MaxPairColumns = 8
+)
+
+// TableSketch is a sketch of the columns of a table.
+type TableSketch struct {
+	DatasetID      string
+	ColumnSketches []*ColumnSketch
+	// Number of records, excluding the header.
+	Rows int
+}
+
+// Update adds a record to the sketch. The first record is the header.
+func (s *TableSketch) Update(record []string) {
+	if s.ColumnSketches == nil {
+		for _, v := range record {
+			s.ColumnSketches = append(s.ColumnSketches, NewColumnSketch(v))
+		}
+	} else {
+		for i, v := range record {
+			s.ColumnSketches[i].Update(v)
+		}
+		s.Rows++
+	}
+}
+
+// PairColumns returns the indexes of the columns that are candidate parts of a
+// composite key, whose pairs are sketched by SketchColumnPairs.
+// A candidate column has at least two distinct values, but not so many that it
+// is a key by itself (more than 90% of the rows). At most MaxPairColumns
+// candidates are returned, in table order.
+func (s *TableSketch) PairColumns() []int {
+	var cols []int
+	for i, c := range s.ColumnSketches {
+		n := c.HyperLogLog.Estimate()
+		if n >= 2 && n*10 <= uint64(s.Rows)*9 {
+			cols = append(cols, i)
+			if len(cols) == MaxPairColumns {
+				break
+			}
+		}
+	}
+	return cols
+}
+
+// Columns returns the sketches of the columns in the form in which they are
+// stored in the column_sketches table. The column IDs are the dataset ID
+// followed by a dash and the column index.
+func (s *TableSketch) Columns() []*database.ColumnSketch {
+	cols := make([]*database.ColumnSketch, len(s.ColumnSketches))
+	for i, c := range s.ColumnSketches {
+		cols[i] = c.Column(fmt.Sprint(s.DatasetID, "-", i), s.DatasetID)
+	}
+	return cols
+}
+
+// ColumnSketch is a sketch of the values of a column.
+type ColumnSketch struct {
+	ColumnName  string
+	Minhash     *lshensemble.Minhash
+	HyperLogLog *hyperloglog.Sketch
+	Sample      []string
+}
+
+// NewColumnSketch returns an empty sketch of the column with the given name.
+func NewColumnSketch(columnName string) *ColumnSketch {
+	return &ColumnSketch{
+		ColumnName:  columnName,
+		Minhash:     lshensemble.NewMinhash(MinhashSeed, MinhashSize),
+		HyperLogLog: hyperloglog.New(),
+		Sample:      make([]string, 0, SampleSize),
+	}
+}
+
+// Column returns the sketch in the form in which it is stored in the
+// column_sketches table.
+func (s *ColumnSketch) Column(columnID, datasetID string) *database.ColumnSketch {
+	return &database.ColumnSketch{
+		ColumnID:      columnID,
+		DatasetID:     datasetID,
+		ColumnName:    s.ColumnName,
+		DistinctCount: int(s.HyperLogLog.Estimate()),
+		Minhash:       s.Minhash.Signature(),
+		Sample:        s.Sample,
+	}
+}
+
+// Update adds a value to the sketch. Empty values are only sampled.
+func (s *ColumnSketch) Update(v string) {
+	if v != "" {
+		b := []byte(v)
+		s.Minhash.Push(b)
+		s.HyperLogLog.Insert(b)
+	}
+
+	if len(s.Sample) < SampleSize {
+		s.Sample = append(s.Sample, v)
+	}
+}
+
+// PairSketch is a sketch of the value pairs in the rows of two columns.
+//
+// The values of a pair are kept in the order of the columns, so a sketch only
+// matches the pairs of columns in the same order.
+type PairSketch struct {
+	// Indexes of the columns
+	Columns     [2]int
+	Minhash     *lshensemble.Minhash
+	HyperLogLog *hyperloglog.Sketch
+}
+
+// NewPairSketch returns an empty sketch of the pair of columns with the given
+// indexes.
+func NewPairSketch(i, j int) *PairSketch {
+	return &PairSketch{
+		Columns:     [2]int{i, j},
+		Minhash:     lshensemble.NewMinhash(MinhashSeed, MinhashSize),
+		HyperLogLog: hyperloglog.New(),
+	}
+}
+
+// Pair returns the sketch in the form in which it is stored in the
+// column_pair_sketches table.
+func (s *PairSketch) Pair(datasetID string) *database.ColumnPairSketch {
+	return &database.ColumnPairSketch{
+		PairID:    PairID(datasetID, s.Columns[0], s.Columns[1]),
+		DatasetID: datasetID,
+		ColumnIDs: [2]string{
+			fmt.Sprint(datasetID, "-", s.Columns[0]),
+			fmt.Sprint(datasetID, "-", s.Columns[1]),
+		},
+		DistinctCount: int(s.HyperLogLog.Estimate()),
+		Minhash:       s.Minhash.Signature(),
+	}
+}
+
+// Update adds the values of a row to the sketch. Rows in which either value is
+// empty are skipped.
+func (s *PairSketch) Update(a, b string) {
+	if a == "" || b == "" {
+		return
+	}
+	t := []byte(Tuple(a, b))
+	s.Minhash.Push(t)
+	s.HyperLogLog.Insert(t)
+}
+
+// PairID returns the ID of the pair of columns with the given indexes: the
+// dataset ID followed by a dash and the column indexes in increasing order,
+// separated by a dash. Pairs are stored in increasing column order.
+func PairID(datasetID string, i, j int) string {
+	if i > j {
+		i, j = j, i
+	}
+	return fmt.Sprint(datasetID, "-", i, "-", j)
+}
+
+// Tuple encodes a tuple of values in their order.
+func Tuple(values ...string) string {
+	return strings.Join(values, "\x1f")
+}
+
+func newCSVReader(r io.Reader) *csv.Reader {
+	cr := csv.NewReader(r)
+	cr.LazyQuotes = true
+	cr.ReuseRecord = true
+	return cr
+}
+
+// SketchCSV sketches the columns of a CSV file with a header.
+// Returns nil if the file is empty.
+func SketchCSV(r io.Reader, datasetID string) (*TableSketch, error) {
+	sketch := TableSketch{DatasetID: datasetID}
+	cr := newCSVReader(r)
+
+	for {
+		record, err := cr.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		sketch.Update(record)
+	}
+	if sketch.ColumnSketches == nil {
+		return nil, nil
+	}
+	return &sketch, nil
+}
+
+// ColumnValues reads the sets of distinct non-empty values of the columns with
+// the given indexes from a CSV file with a header, as sketched by SketchCSV.
+func ColumnValues(r io.Reader, columns []int) ([]map[string]bool, error) {
+	values := make([]map[string]bool, len(columns))
+	for i := range values {
+		values[i] = make(map[string]bool)
+	}
+	cr := newCSVReader(r)
+
+	for header := true; ; header = false {
+		record, err := cr.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if header {
+			continue
+		}
+		for i, col := range columns {
+			if col < len(record) && record[col] != "" {
+				values[i][record[col]] = true
+			}
+		}
+	}
+	return values, nil
+}
+
+// SketchColumnPairs sketches the pairs of the columns with the given indexes of
+// a CSV file with a header, as sketched by SketchCSV. Each pair is sketched in
+// the order of the given columns.
+func SketchColumnPairs(r io.Reader, columns []int) ([]*PairSketch, error) {
+	var pairs [][2]int
+	for i := range columns {
+		for j := i + 1; j < len(columns); j++ {
+			pairs = append(pairs, [2]int{columns[i], columns[j]})
+		}
+	}
+	return SketchPairs(r, pairs)
+}
+
+// SketchPairs sketches the given pairs of columns of a CSV file with a header,
+// as sketched by SketchCSV.
+func SketchPairs(r io.Reader, columns [][2]int) ([]*PairSketch, error) {
+	pairs := make([]*PairSketch, len(columns))
+	for i, c := range columns {
+		pairs[i] = NewPairSketch(c[0], c[1])
+	}
+	cr := newCSVReader(r)
+
+	for header := true; ; header = false {
+		record, err := cr.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if header {
+			continue
+		}
+		for _, p := range pairs {
+			if i, j := p.Columns[0], p.Columns[1]; i < len(record) && j < len(record) {
+				p.Update(record[i], record[j])
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// ColumnTuples reads the set of distinct tuples of the values of the columns
+// with the given indexes, encoded by Tuple, from a CSV file with a header.
+// Rows in which any of the values is empty are skipped, as by PairSketch.
+func ColumnTuples(r io.Reader, columns []int) (map[string]bool, error) {
+	tuples := make(map[string]bool)
+	values := make([]string, len(columns))
+	cr := newCSVReader(r)
+
+	for header := true; ; header = false {
+		record, err := cr.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if header {
+			continue
+		}
+		complete := true
+		for i, col := range columns {
+			if col >= len(record) || record[col] == "" {
+				complete = false
+				break
+			}
+			values[i] = record[col]
+		}
+		if complete {
+			tuples[Tuple(values...)] = true
+		}
+	}
+	return tuples, nil
+}
@@ -0,0 +1,99 @@
+package sketch
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSketchCSV(t *testing.T) {
+	csv := "zip,borough\n10001,Manhattan\n11201,Brooklyn\n10001,\n"
+	ts, err := SketchCSV(strings.NewReader(csv), "abcd-1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols := ts.Columns()
+	if len(cols) != 2 {
+		t.Fatalf("got %v columns, want 2", len(cols))
+	}
+	tests := []struct {
+		id, name      string
+		distinctCount int
+		sample        []string
+	}{
+		{"abcd-1234-0", "zip", 2, []string{"10001", "11201", "10001"}},
+		{"abcd-1234-1", "borough", 2, []string{"Manhattan", "Brooklyn", ""}},
+	}
+	for i, tt := range tests {
+		c := cols[i]
+		if c.ColumnID != tt.id || c.DatasetID != "abcd-1234" || c.ColumnName != tt.name {
+			t.Errorf("column %v: got %v %v %v", i, c.ColumnID, c.DatasetID, c.ColumnName)
+		}
+		if c.DistinctCount != tt.distinctCount {
+			t.Errorf("column %v: distinct count %v, want %v", i, c.DistinctCount, tt.distinctCount)
+		}
+		if !reflect.DeepEqual(c.Sample, tt.sample) {
+			t.Errorf("column %v: sample %q, want %q", i, c.Sample, tt.sample)
+		}
+		if len(c.Minhash) != MinhashSize {
+			t.Errorf("column %v: minhash size %v, want %v", i, len(c.Minhash), MinhashSize)
+		}
+	}
+	if ts, err := SketchCSV(strings.NewReader(""), "abcd-1234"); err != nil || ts != nil {
+		t.Errorf("SketchCSV of empty file = %v, %v; want nil, nil", ts, err)
+	}
+}
+
+func TestSketchColumnPairs(t *testing.T) {
+	csv := "year,borough,id\n2019,Bronx,1\n2019,Queens,2\n2020,Bronx,3\n2020,Bronx,4\n2020,,5\n"
+	ts, err := SketchCSV(strings.NewReader(csv), "abcd-1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Rows != 5 {
+		t.Errorf("rows = %v, want 5", ts.Rows)
+	}
+	cols := ts.PairColumns()
+	if want := []int{0, 1}; !reflect.DeepEqual(cols, want) {
+		t.Fatalf("pair columns = %v, want %v", cols, want)
+	}
+	pairs, err := SketchColumnPairs(strings.NewReader(csv), cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 1 {
+		t.Fatalf("got %v pairs, want 1", len(pairs))
+	}
+	p := pairs[0].Pair("abcd-1234")
+	if p.PairID != "abcd-1234-0-1" || p.ColumnIDs != [2]string{"abcd-1234-0", "abcd-1234-1"} {
+		t.Errorf("got pair %v with columns %v", p.PairID, p.ColumnIDs)
+	}
+	if p.DistinctCount != 3 {
+		t.Errorf("distinct count = %v, want 3", p.DistinctCount)
+	}
+
+	// The sketch depends on the order of the columns.
+	swapped := "borough,year\nBronx,2019\nQueens,2019\nBronx,2020\n"
+	pairs2, err := SketchColumnPairs(strings.NewReader(swapped), []int{0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(pairs2[0].Minhash.Signature(), p.Minhash) {
+		t.Error("pair sketch does not depend on column order")
+	}
+	pairs2, err = SketchPairs(strings.NewReader(swapped), [][2]int{{1, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pairs2[0].Minhash.Signature(), p.Minhash) {
+		t.Error("pair sketch of the swapped columns differs")
+	}
+
+	tuples, err := ColumnTuples(strings.NewReader(csv), []int{1, 0, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tuples) != 4 || !tuples[Tuple("Queens", "2019", "2")] {
+		t.Errorf("got tuples %v", tuples)
+	}
+}
@@ -18,6 +18,17 @@ func Add(a, b []float32) {
 	}
 }
 
+// AddScaled adds b scaled by n to a.
+// AddScaled panics if the vector lengths are unequal.
+func AddScaled(a, b []float32, n float32) {
+	if len(a) != len(b) {
+		panic("vector lengths not equal")
+	}
+	for i, v := range b {
+		a[i] += n * v
+	}
+}
+
 // Scale scales a by n.
 func Scale(a []float32, n float32) {
 	for i := range a {
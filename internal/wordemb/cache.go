@@ -0,0 +1,146 @@
+package wordemb
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultCacheSize is the number of word vectors a FastText model caches by
+// default.
+const DefaultCacheSize = 50000
+
+// CacheStats are the statistics of the word vector cache of a model.
+type CacheStats struct {
+	// Number of lookups of words found and not found in the cache.
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	// Number of words in the cache, including preloaded words, and maximum
+	// number of words other than preloaded ones.
+	Size     int `json:"size"`
+	Capacity int `json:"capacity"`
+	// Number of preloaded words.
+	Preloaded int `json:"preloaded"`
+}
+
+// HitRate returns the fraction of lookups found in the cache, 0 if there
+// were none.
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// vectorCache is an LRU cache of word vectors. Words without a vector are
+// cached with a nil vector so that they are not looked up again. Preloaded
+// words are never evicted.
+type vectorCache struct {
+	mu       sync.Mutex
+	capacity int
+	// Words in order of use, most recent first.
+	lru       *list.List
+	entries   map[string]*list.Element
+	preloaded map[string][]float32
+	hits      int64
+	misses    int64
+}
+
+type cacheEntry struct {
+	word string
+	vec  []float32
+}
+
+func newVectorCache(capacity int) *vectorCache {
+	return &vectorCache{
+		capacity:  capacity,
+		lru:       list.New(),
+		entries:   make(map[string]*list.Element),
+		preloaded: make(map[string][]float32),
+	}
+}
+
+// get returns the cached vector of word, and whether the word is cached.
+func (c *vectorCache) get(word string) ([]float32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if vec, ok := c.preloaded[word]; ok {
+		c.hits++
+		return vec, true
+	}
+	if e, ok := c.entries[word]; ok {
+		c.hits++
+		c.lru.MoveToFront(e)
+		return e.Value.(*cacheEntry).vec, true
+	}
+	c.misses++
+	return nil, false
+}
+
+// add caches the vector of word, evicting the least recently used words if
+// the cache is full.
+func (c *vectorCache) add(word string, vec []float32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.preloaded[word]; ok {
+		return
+	}
+	if e, ok := c.entries[word]; ok {
+		e.Value.(*cacheEntry).vec = vec
+		c.lru.MoveToFront(e)
+		return
+	}
+	if c.capacity <= 0 {
+		return
+	}
+	c.entries[word] = c.lru.PushFront(&cacheEntry{word, vec})
+	c.evict()
+}
+
+// preload caches the vectors of words, which are never evicted.
+// The vector of a word without one is nil.
+func (c *vectorCache) preload(words []string, vecs map[string][]float32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, word := range words {
+		c.preloaded[word] = vecs[word]
+		if e, ok := c.entries[word]; ok {
+			c.lru.Remove(e)
+			delete(c.entries, word)
+		}
+	}
+}
+
+func (c *vectorCache) setCapacity(capacity int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if capacity < 0 {
+		capacity = 0
+	}
+	c.capacity = capacity
+	c.evict()
+}
+
+func (c *vectorCache) evict() {
+	for c.lru.Len() > c.capacity {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*cacheEntry).word)
+	}
+}
+
+func (c *vectorCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Size:      c.lru.Len() + len(c.preloaded),
+		Capacity:  c.capacity,
+		Preloaded: len(c.preloaded),
+	}
+}
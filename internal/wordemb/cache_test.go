@@ -0,0 +1,105 @@
+package wordemb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ekzhu/go-fasttext"
+)
+
+func TestVectorCache(t *testing.T) {
+	c := newVectorCache(2)
+	c.add("a", []float32{1})
+	c.add("b", nil)
+	c.get("a")
+	c.add("c", []float32{3})
+
+	if _, ok := c.get("b"); ok {
+		t.Error("least recently used word b was not evicted")
+	}
+	if vec, ok := c.get("a"); !ok || vec[0] != 1 {
+		t.Errorf("get(a) = %v, %v, want [1], true", vec, ok)
+	}
+	c.preload([]string{"d"}, nil)
+	c.setCapacity(0)
+	if _, ok := c.get("d"); !ok {
+		t.Error("preloaded word d was evicted")
+	}
+
+	want := CacheStats{Hits: 3, Misses: 1, Size: 1, Preloaded: 1}
+	if got := c.stats(); got != want {
+		t.Errorf("stats() = %+v, want %+v", got, want)
+	}
+	if got := want.HitRate(); got != 0.75 {
+		t.Errorf("HitRate() = %v, want 0.75", got)
+	}
+}
+
+// writeFastTextDB builds a fastText database of the given words, whose
+// vectors are the basis vectors of their indexes.
+func writeFastTextDB(t *testing.T, path string, words []string) {
+	var vec strings.Builder
+	fmt.Fprintf(&vec, "%d %d\n", len(words), fasttext.Dim)
+	for i, word := range words {
+		vec.WriteString(word)
+		for j := 0; j < fasttext.Dim; j++ {
+			if i == j {
+				vec.WriteString(" 1")
+			} else {
+				vec.WriteString(" 0")
+			}
+		}
+		vec.WriteString("\n")
+	}
+	ft := fasttext.NewFastText(path)
+	defer ft.Close()
+	if err := ft.BuildDB(strings.NewReader(vec.String())); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFastTextCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fasttext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "fasttext.sqlite")
+	writeFastTextDB(t, path, []string{"school", "district", "park"})
+	ft := NewFastText(path)
+	defer ft.Close()
+
+	// More words than are looked up by a single query.
+	words := make([]string, maxLookupWords+10)
+	for i := range words {
+		words[i] = fmt.Sprintf("w%d", i)
+	}
+	words[len(words)-1] = "park"
+	embs, err := ft.lookup(words)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(embs) != 1 || embs["park"][2] != 1 {
+		t.Errorf("lookup found %d words, want park", len(embs))
+	}
+
+	if err := ft.Preload([][]string{{"school"}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := ft.Embed([]string{"school district"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first embedding looks up district and the phrase school_district,
+	// and the second finds them in the cache.
+	stats := ft.CacheStats()
+	if stats.Hits != 4 || stats.Misses != 2 || stats.Preloaded != 1 {
+		t.Errorf("CacheStats() = %+v, want 4 hits, 2 misses and 1 preloaded word", stats)
+	}
+}
@@ -0,0 +1,99 @@
+package wordemb
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+	"github.com/ekzhu/go-fasttext"
+)
+
+// Maximum number of words looked up by a query, below SQLite's default limit
+// on the number of query parameters.
+const maxLookupWords = 999
+
+// FastText is an Embedder that averages the word vectors of a fastText
+// database built by build_fasttext. The vectors looked up are cached, up to
+// DefaultCacheSize words by default.
+type FastText struct {
+	averager
+	db *sql.DB
+}
+
+// NewFastText opens the fastText database at path.
+func NewFastText(path string) *FastText {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		// sql.Open only fails if the driver is not registered.
+		panic(err)
+	}
+	ft := &FastText{db: db}
+	ft.averager = averager{
+		dim:    fasttext.Dim,
+		lookup: ft.lookup,
+		cache:  newVectorCache(DefaultCacheSize),
+	}
+	return ft
+}
+
+// lookup looks up the words in batches of up to maxLookupWords.
+func (ft *FastText) lookup(words []string) (map[string][]float32, error) {
+	embs := make(map[string][]float32, len(words))
+
+	for len(words) > 0 {
+		n := len(words)
+		if n > maxLookupWords {
+			n = maxLookupWords
+		}
+		args := make([]interface{}, n)
+		for i, word := range words[:n] {
+			args[i] = word
+		}
+		if err := ft.query(embs, args); err != nil {
+			return nil, err
+		}
+		words = words[n:]
+	}
+	return embs, nil
+}
+
+func (ft *FastText) query(embs map[string][]float32, words []interface{}) error {
+	rows, err := ft.db.Query(`
+	SELECT word, emb
+	FROM `+fasttext.TableName+`
+	WHERE word IN (?`+strings.Repeat(", ?", len(words)-1)+`)`, words...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var word string
+		var data []byte
+		if err := rows.Scan(&word, &data); err != nil {
+			return err
+		}
+		if len(data) != 4*fasttext.Dim {
+			return fmt.Errorf("fastText vector of %q has %d bytes, want %d",
+				word, len(data), 4*fasttext.Dim)
+		}
+		emb := make([]float32, fasttext.Dim)
+		for i := range emb {
+			emb[i] = math.Float32frombits(fasttext.ByteOrder.Uint32(data[4*i:]))
+		}
+		vec32.Normalize(emb)
+		embs[word] = emb
+	}
+	return rows.Err()
+}
+
+// Close closes the fastText database and the subword model, if any.
+func (ft *FastText) Close() error {
+	err := ft.db.Close()
+	if serr := ft.closeSubwords(); err == nil {
+		err = serr
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package wordemb
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// GloVe is an Embedder that averages word vectors loaded from a GloVe text
+// file, in which each line is a word followed by the components of its
+// vector, separated by spaces. A word2vec header line with the number of
+// words and the dimension is skipped.
+//
+// GloVe vocabularies are lowercase, so words without a vector are looked up
+// in lowercase.
+type GloVe struct {
+	averager
+	// Maps words to the offsets of their vectors in vecs.
+	words map[string]int
+	vecs  []float32
+}
+
+// LoadGloVe loads the word vectors of the GloVe text file at path.
+func LoadGloVe(path string) (*GloVe, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	g := &GloVe{words: make(map[string]int)}
+	g.averager.lookup = g.lookup
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || line == 1 && len(fields) == 2 {
+			continue
+		}
+		if g.dim == 0 {
+			g.dim = len(fields) - 1
+		}
+		if len(fields)-1 != g.dim {
+			return nil, fmt.Errorf("%s:%d: vector has dimension %d, want %d",
+				path, line, len(fields)-1, g.dim)
+		}
+		offset := len(g.vecs)
+		for _, field := range fields[1:] {
+			x, err := strconv.ParseFloat(field, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+			}
+			g.vecs = append(g.vecs, float32(x))
+		}
+		if vec32.Norm(g.vecs[offset:]) == 0 {
+			g.vecs = g.vecs[:offset]
+			continue
+		}
+		vec32.Normalize(g.vecs[offset:])
+		g.words[fields[0]] = offset
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if g.dim == 0 {
+		return nil, fmt.Errorf("%s: no word vectors", path)
+	}
+	return g, nil
+}
+
+func (g *GloVe) lookup(words []string) (map[string][]float32, error) {
+	embs := make(map[string][]float32, len(words))
+
+	for _, word := range words {
+		offset, ok := g.words[word]
+		if !ok {
+			if offset, ok = g.words[strings.ToLower(word)]; !ok {
+				continue
+			}
+		}
+		embs[word] = g.vecs[offset : offset+g.dim : offset+g.dim]
+	}
+	return embs, nil
+}
+
+// Close closes the subword model, if any.
+func (g *GloVe) Close() error {
+	return g.closeSubwords()
+}
@@ -0,0 +1,66 @@
+package wordemb
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func loadTestGloVe(t *testing.T, data string) (*GloVe, error) {
+	dir, err := ioutil.TempDir("", "glove")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "glove.txt")
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadGloVe(path)
+}
+
+func TestGloVe(t *testing.T) {
+	g, err := loadTestGloVe(t, `3 2
+school 2 0
+district 0 3
+the 1 1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Dim() != 2 {
+		t.Fatalf("Dim() = %v, want 2", g.Dim())
+	}
+
+	vec, err := g.Embed([]string{"The School", "districts"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vec[0] != 1 || vec[1] != 0 {
+		t.Errorf("Embed(school) = %v, want [1 0]", vec)
+	}
+
+	vecs, err := g.EmbedBatch([][]string{{"school-district"}, {"unknown"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := float32(1 / math.Sqrt2)
+	if v := vecs[0]; len(v) != 2 || math.Abs(float64(v[0]-want)) > 1e-6 || math.Abs(float64(v[1]-want)) > 1e-6 {
+		t.Errorf("EmbedBatch(school-district) = %v, want [%v %v]", v, want, want)
+	}
+	if vecs[1] != nil {
+		t.Errorf("EmbedBatch(unknown) = %v, want nil", vecs[1])
+	}
+	if _, err := g.Embed([]string{"unknown"}); err != ErrNoEmb {
+		t.Errorf("Embed(unknown) error = %v, want ErrNoEmb", err)
+	}
+}
+
+func TestGloVeDimensionMismatch(t *testing.T) {
+	if _, err := loadTestGloVe(t, "a 1 2\nb 1 2 3\n"); err == nil {
+		t.Error("LoadGloVe succeeded with vectors of different dimensions")
+	}
+}
@@ -0,0 +1,313 @@
+package wordemb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// Magic number and version of fastText binary models.
+const (
+	fasttextMagic   = 793712314
+	fasttextVersion = 12
+)
+
+// Lengths of the character n-grams that embed words without a vector if
+// there is no subword model.
+const (
+	minNgram = 3
+	maxNgram = 6
+)
+
+// SubwordModel embeds words by the vectors of their character n-grams in a
+// fastText binary (.bin) model, as fastText embeds out-of-vocabulary words.
+//
+// Only the header and dictionary of the model are read into memory; the
+// n-gram vectors are read from the file when words are looked up.
+type SubwordModel struct {
+	f          *os.File
+	dim        int
+	minn, maxn int
+	bucket     uint32
+	nwords     int64
+	// Number of n-gram buckets kept by pruning, -1 if the model is not
+	// pruned.
+	pruneSize int64
+	// Maps the buckets kept by pruning to their rows.
+	pruneIdx map[int32]int32
+	// Offset of the input matrix in the file.
+	matrix int64
+}
+
+// OpenSubwordModel opens the fastText binary model at path. Quantized (.ftz)
+// models are not supported.
+func OpenSubwordModel(path string) (*SubwordModel, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := readSubwordModel(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return m, nil
+}
+
+func readSubwordModel(f *os.File) (*SubwordModel, error) {
+	r := bufio.NewReader(f)
+	read := func(data ...interface{}) error {
+		for _, d := range data {
+			if err := binary.Read(r, binary.LittleEndian, d); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var magic, version int32
+	if err := read(&magic, &version); err != nil {
+		return nil, err
+	}
+	if magic != fasttextMagic {
+		return nil, errors.New("not a fastText binary model")
+	}
+	if version != fasttextVersion {
+		return nil, fmt.Errorf("unsupported fastText model version %d", version)
+	}
+
+	// Arguments: dim, ws, epoch, minCount, neg, wordNgrams, loss, model,
+	// bucket, minn, maxn and lrUpdateRate, and the sampling threshold t.
+	var args [12]int32
+	var t float64
+	if err := read(&args, &t); err != nil {
+		return nil, err
+	}
+	m := &SubwordModel{
+		f:      f,
+		dim:    int(args[0]),
+		bucket: uint32(args[8]),
+		minn:   int(args[9]),
+		maxn:   int(args[10]),
+	}
+
+	// Dictionary: the words and labels, each followed by its count and type,
+	// and the pruned buckets.
+	var size, nwords, nlabels int32
+	var ntokens int64
+	if err := read(&size, &nwords, &nlabels, &ntokens, &m.pruneSize); err != nil {
+		return nil, err
+	}
+	m.nwords = int64(nwords)
+	for i := int32(0); i < size; i++ {
+		if _, err := r.ReadBytes(0); err != nil {
+			return nil, err
+		}
+		var count int64
+		var typ int8
+		if err := read(&count, &typ); err != nil {
+			return nil, err
+		}
+	}
+	if m.pruneSize > 0 {
+		m.pruneIdx = make(map[int32]int32, m.pruneSize)
+		for i := int64(0); i < m.pruneSize; i++ {
+			var bucket, row int32
+			if err := read(&bucket, &row); err != nil {
+				return nil, err
+			}
+			m.pruneIdx[bucket] = row
+		}
+	}
+
+	var quant bool
+	var rows, cols int64
+	if err := read(&quant); err != nil {
+		return nil, err
+	}
+	if quant {
+		return nil, errors.New("quantized models are not supported")
+	}
+	if err := read(&rows, &cols); err != nil {
+		return nil, err
+	}
+	if cols != int64(m.dim) {
+		return nil, fmt.Errorf("input matrix has %d columns, want %d", cols, m.dim)
+	}
+	pos, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	m.matrix = pos - int64(r.Buffered())
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < m.matrix+rows*cols*4 {
+		return nil, errors.New("input matrix is truncated")
+	}
+	if m.pruneSize < 0 && rows < m.nwords+int64(m.bucket) {
+		return nil, fmt.Errorf("input matrix has %d rows, want %d", rows, m.nwords+int64(m.bucket))
+	}
+	return m, nil
+}
+
+// Dim returns the dimension of the vectors.
+func (m *SubwordModel) Dim() int {
+	return m.dim
+}
+
+// Close closes the model file.
+func (m *SubwordModel) Close() error {
+	return m.f.Close()
+}
+
+// subwordHash is the FNV-1a hash of fastText, which sign-extends bytes.
+func subwordHash(s string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(int8(s[i]))
+		h *= 16777619
+	}
+	return h
+}
+
+// ngramRows returns the rows of the input matrix of the character n-grams of
+// word, delimited by "<" and ">", with lengths from minn to maxn characters.
+func (m *SubwordModel) ngramRows(word string) []int64 {
+	if m.pruneSize == 0 || m.bucket == 0 {
+		return nil
+	}
+	w := "<" + word + ">"
+	var rows []int64
+
+	for i := 0; i < len(w); i++ {
+		if !isRuneStart(w[i]) {
+			continue
+		}
+		for j, n := i, 1; j < len(w) && n <= m.maxn; n++ {
+			j++
+			for j < len(w) && !isRuneStart(w[j]) {
+				j++
+			}
+			// Single characters at the word boundaries are not n-grams.
+			if n < m.minn || n == 1 && (i == 0 || j == len(w)) {
+				continue
+			}
+			bucket := int32(subwordHash(w[i:j]) % m.bucket)
+			if m.pruneSize > 0 {
+				row, ok := m.pruneIdx[bucket]
+				if !ok {
+					continue
+				}
+				bucket = row
+			}
+			rows = append(rows, m.nwords+int64(bucket))
+		}
+	}
+	return rows
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// lookup returns the normalized mean of the n-gram vectors of each word that
+// has n-grams.
+func (m *SubwordModel) lookup(words []string) (map[string][]float32, error) {
+	embs := make(map[string][]float32, len(words))
+	buf := make([]byte, 4*m.dim)
+
+	for _, word := range words {
+		rows := m.ngramRows(word)
+		if len(rows) == 0 {
+			continue
+		}
+		vec := make([]float32, m.dim)
+		for _, row := range rows {
+			if _, err := m.f.ReadAt(buf, m.matrix+row*int64(len(buf))); err != nil {
+				return nil, err
+			}
+			for i := range vec {
+				vec[i] += math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+			}
+		}
+		if vec32.Norm(vec) == 0 {
+			continue
+		}
+		vec32.Normalize(vec)
+		embs[word] = vec
+	}
+	return embs, nil
+}
+
+// ngrams returns the distinct lowercase character n-grams of word, other than
+// stop words, with lengths from minNgram to maxNgram characters, shorter than
+// the word.
+func ngrams(word string) []string {
+	runes := []rune(strings.ToLower(word))
+	seen := make(map[string]bool)
+	var out []string
+
+	for n := minNgram; n <= maxNgram && n < len(runes); n++ {
+		for i := 0; i+n <= len(runes); i++ {
+			ngram := string(runes[i : i+n])
+			if !seen[ngram] && !isStopword(ngram) {
+				seen[ngram] = true
+				out = append(out, ngram)
+			}
+		}
+	}
+	return out
+}
+
+// lookupNgrams embeds each word by the mean of the vectors of its character
+// n-grams that are in the vocabulary, weighted by their lengths, since longer
+// n-grams are more specific.
+func (a *averager) lookupNgrams(words []string) (map[string][]float32, error) {
+	wordNgrams := make([][]string, len(words))
+	seen := make(map[string]bool)
+	var distinct []string
+
+	for i, word := range words {
+		wordNgrams[i] = ngrams(word)
+		for _, ngram := range wordNgrams[i] {
+			if !seen[ngram] {
+				seen[ngram] = true
+				distinct = append(distinct, ngram)
+			}
+		}
+	}
+	ngramEmbs, err := a.cachedLookup(distinct)
+	if err != nil {
+		return nil, err
+	}
+	embs := make(map[string][]float32)
+
+	for i, word := range words {
+		var vec []float32
+		for _, ngram := range wordNgrams[i] {
+			emb, ok := ngramEmbs[ngram]
+			if !ok {
+				continue
+			}
+			if vec == nil {
+				vec = make([]float32, a.dim)
+			}
+			vec32.AddScaled(vec, emb, float32(len([]rune(ngram))))
+		}
+		if vec != nil && vec32.Norm(vec) > 0 {
+			vec32.Normalize(vec)
+			embs[word] = vec
+		}
+	}
+	return embs, nil
+}
@@ -0,0 +1,116 @@
+package wordemb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func approxEqual(a, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(float64(a[i]-b[i])) > 1e-6 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSubwordHash(t *testing.T) {
+	for _, s := range []string{"<sc", "sch", "ool>", "<school>"} {
+		h := fnv.New32a()
+		h.Write([]byte(s))
+		if got, want := subwordHash(s), h.Sum32(); got != want {
+			t.Errorf("subwordHash(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+// writeSubwordModel writes a fastText binary model of 2-dimensional vectors
+// with one word and one n-gram bucket, whose vector is ngramVec.
+func writeSubwordModel(t *testing.T, path string, ngramVec []float32) {
+	var buf bytes.Buffer
+	write := func(data ...interface{}) {
+		for _, d := range data {
+			if err := binary.Write(&buf, binary.LittleEndian, d); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	write(int32(fasttextMagic), int32(fasttextVersion))
+	// dim, ws, epoch, minCount, neg, wordNgrams, loss, model, bucket, minn,
+	// maxn, lrUpdateRate, t
+	write([12]int32{2, 5, 5, 5, 5, 1, 1, 1, 1, 3, 6, 100}, float64(1e-4))
+	// size, nwords, nlabels, ntokens, pruneidx_size
+	write(int32(1), int32(1), int32(0), int64(10), int64(-1))
+	buf.WriteString("school\x00")
+	write(int64(10), int8(0))
+	// Input matrix: the word row and the bucket row.
+	write(false, int64(2), int64(2), []float32{9, 9}, ngramVec)
+
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubwordModel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "subword")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "model.bin")
+	writeSubwordModel(t, path, []float32{3, 4})
+	m, err := OpenSubwordModel(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if m.Dim() != 2 {
+		t.Errorf("Dim() = %d, want 2", m.Dim())
+	}
+	embs, err := m.lookup([]string{"schoool", "ab"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, word := range []string{"schoool", "ab"} {
+		if want := []float32{0.6, 0.8}; !approxEqual(embs[word], want) {
+			t.Errorf("lookup(%s) = %v, want %v", word, embs[word], want)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte("not a model"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenSubwordModel(path); err == nil {
+		t.Error("OpenSubwordModel succeeded on an invalid file")
+	}
+}
+
+func TestNgramFallback(t *testing.T) {
+	g, err := loadTestGloVe(t, `sch 1 0
+ool 0 1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vec, err := g.Embed([]string{"Schoool"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float32{1 / math.Sqrt2, 1 / math.Sqrt2}; !approxEqual(vec, want) {
+		t.Errorf("Embed(Schoool) = %v, want %v", vec, want)
+	}
+	if _, err := g.Embed([]string{"xyzzy"}); err != ErrNoEmb {
+		t.Errorf("Embed(xyzzy) error = %v, want ErrNoEmb", err)
+	}
+}
@@ -0,0 +1,154 @@
+package wordemb
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Separator of the words of a phrase in the vocabulary, as in fastText's and
+// word2vec's "New_York".
+const phraseSep = "_"
+
+// token is a word of a text. Words split from a compound, such as
+// "school-district" or "PermitIssueDate", are looked up as a whole first.
+type token struct {
+	word string
+	// Compound starting with the word and its number of words, if any.
+	compound string
+	n        int
+}
+
+// Tokenize splits text into words. Words are runs of Unicode letters and
+// digits. Identifiers are split at underscores and case changes, so
+// "zip_code" is split into "zip" and "code", and "PermitIssueDate" into
+// "Permit", "Issue" and "Date". Hyphenated terms are split at hyphens.
+func Tokenize(text string) []string {
+	var words []string
+	for _, t := range tokenize(text) {
+		words = append(words, t.word)
+	}
+	return words
+}
+
+func tokenize(text string) []token {
+	var tokens []token
+	for _, chunk := range strings.FieldsFunc(text, isSeparator) {
+		var words []string
+		for _, part := range strings.FieldsFunc(chunk, isHyphen) {
+			words = append(words, splitCase(part)...)
+		}
+		if len(words) == 0 {
+			continue
+		}
+		start := len(tokens)
+		for _, word := range words {
+			tokens = append(tokens, token{word: word})
+		}
+		if len(words) > 1 {
+			tokens[start].compound = strings.Trim(chunk, "-‐")
+			tokens[start].n = len(words)
+		}
+	}
+	return tokens
+}
+
+// isSeparator reports whether r separates words that are not part of the
+// same compound.
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsMark(r) && !isHyphen(r)
+}
+
+func isHyphen(r rune) bool {
+	return r == '-' || r == '‐'
+}
+
+// splitCase splits an identifier at lowercase or digit to uppercase changes,
+// and before the last letter of a run of uppercase letters followed by a
+// lowercase letter, so that "HTTPServer" is split into "HTTP" and "Server".
+func splitCase(s string) []string {
+	runes := []rune(s)
+	var words []string
+	start := 0
+
+	for i := 1; i < len(runes); i++ {
+		prev, r := runes[i-1], runes[i]
+		if !unicode.IsUpper(r) {
+			continue
+		}
+		if unicode.IsLower(prev) || unicode.IsNumber(prev) ||
+			unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(words, string(runes[start:]))
+}
+
+func isStopword(word string) bool {
+	return stopwords[strings.ToLower(word)]
+}
+
+// term is a word, compound or phrase of a text that has a vector.
+type term struct {
+	// Key of the vector in the vocabulary.
+	key string
+	// Words of the term other than stop words.
+	words []string
+}
+
+// candidates returns the words, compounds and phrases of tokens that are
+// looked up in the vocabulary. Phrases are pairs of adjacent words, neither of
+// which is a stop word.
+func candidates(tokens []token) []string {
+	var keys []string
+	for i, t := range tokens {
+		if t.n > 1 {
+			keys = append(keys, t.compound)
+		}
+		if isStopword(t.word) {
+			continue
+		}
+		keys = append(keys, t.word)
+		if i+1 < len(tokens) && !isStopword(tokens[i+1].word) {
+			keys = append(keys, t.word+phraseSep+tokens[i+1].word)
+		}
+	}
+	return keys
+}
+
+// terms returns the terms of tokens that have a vector in embs. Compounds are
+// preferred to phrases, and phrases to single words.
+func terms(tokens []token, embs map[string][]float32) []term {
+	var out []term
+	for i := 0; i < len(tokens); {
+		t := tokens[i]
+		if _, ok := embs[t.compound]; ok && t.n > 1 {
+			out = append(out, term{t.compound, termWords(tokens[i : i+t.n])})
+			i += t.n
+			continue
+		}
+		if i+1 < len(tokens) && !isStopword(t.word) {
+			phrase := t.word + phraseSep + tokens[i+1].word
+			if _, ok := embs[phrase]; ok {
+				out = append(out, term{phrase, termWords(tokens[i : i+2])})
+				i += 2
+				continue
+			}
+		}
+		if _, ok := embs[t.word]; ok && !isStopword(t.word) {
+			out = append(out, term{t.word, []string{t.word}})
+		}
+		i++
+	}
+	return out
+}
+
+func termWords(tokens []token) []string {
+	var words []string
+	for _, t := range tokens {
+		if !isStopword(t.word) {
+			words = append(words, t.word)
+		}
+	}
+	return words
+}
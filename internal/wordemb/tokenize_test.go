@@ -0,0 +1,58 @@
+package wordemb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"zip_code", []string{"zip", "code"}},
+		{"PermitIssueDate", []string{"Permit", "Issue", "Date"}},
+		{"HTTPServer2ndAve", []string{"HTTP", "Server2nd", "Ave"}},
+		{"school-district, K-12", []string{"school", "district", "K", "12"}},
+		{"Café São Paulo", []string{"Café", "São", "Paulo"}},
+		{"--", nil},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestPhrases(t *testing.T) {
+	g, err := loadTestGloVe(t, `new 1 0 0
+york 0 1 0
+new_york 0 0 1
+school-district 0 0 2
+school 1 0 0
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		text string
+		want []float32
+	}{
+		{"New York", []float32{0, 0, 1}},
+		{"new_york", []float32{0, 0, 1}},
+		{"NewYork", []float32{0, 0, 1}},
+		{"School-District", []float32{0, 0, 1}},
+		// Phrases do not include stop words.
+		{"new of york", []float32{0.70710677, 0.70710677, 0}},
+	}
+	for _, tt := range tests {
+		vec, err := g.Embed([]string{tt.text})
+		if err != nil {
+			t.Errorf("Embed(%q): %v", tt.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(vec, tt.want) {
+			t.Errorf("Embed(%q) = %v, want %v", tt.text, vec, tt.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package wordemb
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+)
+
+// Weighting modes of Weights.
+const (
+	// TFIDF weights each occurrence of a word by its inverse document
+	// frequency, so that a word is weighted by its TF-IDF.
+	TFIDF = "tfidf"
+	// SIF weights each occurrence of a word by its smooth inverse frequency
+	// a/(a+p(w)), where p(w) is the frequency of the word in the corpus, and
+	// removes the first principal component of the corpus embeddings, as in
+	// "A Simple but Tough-to-Beat Baseline for Sentence Embeddings" (Arora et
+	// al., 2017).
+	SIF = "sif"
+)
+
+// Parameter a of smooth inverse frequency weights.
+const sifParam = 1e-3
+
+// Number of power iterations used to compute the first principal component.
+const powerIterations = 50
+
+// Weights are the word statistics of a corpus of texts, used to weight the
+// word vectors averaged by a Model, so that words frequent in the corpus
+// count less.
+//
+// Words are counted in lowercase. Words that are not in the corpus have the
+// largest weight.
+type Weights struct {
+	// Weighting mode, TFIDF or SIF.
+	Mode string
+	// Number of texts and of word occurrences counted.
+	Docs, Words int
+	// Number of occurrences of each word and number of texts containing it.
+	Freq, DocFreq map[string]int
+	// First principal component of the embeddings of the corpus, which is
+	// removed from SIF embeddings. nil until computed by ComputeComponent.
+	Component []float32
+}
+
+// NewWeights creates empty Weights with the given mode.
+func NewWeights(mode string) (*Weights, error) {
+	if mode != TFIDF && mode != SIF {
+		return nil, fmt.Errorf("invalid weighting mode %q", mode)
+	}
+	return &Weights{
+		Mode:    mode,
+		Freq:    make(map[string]int),
+		DocFreq: make(map[string]int),
+	}, nil
+}
+
+// Count adds the words of a text of the corpus to the statistics.
+func (w *Weights) Count(text []string) {
+	seen := make(map[string]bool)
+	for _, word := range words(text) {
+		word = strings.ToLower(word)
+		w.Freq[word]++
+		w.Words++
+		if !seen[word] {
+			seen[word] = true
+			w.DocFreq[word]++
+		}
+	}
+	w.Docs++
+}
+
+// weight returns the weight of an occurrence of word, 1 if w is nil.
+func (w *Weights) weight(word string) float32 {
+	if w == nil {
+		return 1
+	}
+	word = strings.ToLower(word)
+	switch w.Mode {
+	case TFIDF:
+		// Smoothed as if a text contained every word.
+		return float32(math.Log(float64(1+w.Docs)/float64(1+w.DocFreq[word])) + 1)
+	case SIF:
+		var p float64
+		if w.Words > 0 {
+			p = float64(w.Freq[word]) / float64(w.Words)
+		}
+		return float32(sifParam / (sifParam + p))
+	}
+	return 1
+}
+
+// ComputeComponent computes the first principal component of the embeddings
+// of the corpus by power iteration, to be removed from SIF embeddings.
+// As in the SIF paper, the embeddings are not centered.
+func (w *Weights) ComputeComponent(vecs [][]float32) {
+	if len(vecs) == 0 {
+		return
+	}
+	dim := len(vecs[0])
+
+	// The mean direction is a good start since the vectors are not centered.
+	u := make([]float32, dim)
+	for _, v := range vecs {
+		vec32.Add(u, v)
+	}
+	if vec32.Norm(u) == 0 {
+		u[0] = 1
+	}
+	vec32.Normalize(u)
+
+	next := make([]float32, dim)
+	for i := 0; i < powerIterations; i++ {
+		for j := range next {
+			next[j] = 0
+		}
+		for _, v := range vecs {
+			vec32.AddScaled(next, v, vec32.Dot(v, u))
+		}
+		if vec32.Norm(next) == 0 {
+			return
+		}
+		vec32.Normalize(next)
+		u, next = next, u
+	}
+	w.Component = u
+}
+
+// RemoveComponent removes the projection of vec on the first principal
+// component of the corpus embeddings, if w are SIF weights with a component.
+func (w *Weights) RemoveComponent(vec []float32) {
+	if w == nil || w.Mode != SIF || w.Component == nil {
+		return
+	}
+	vec32.AddScaled(vec, w.Component, -vec32.Dot(vec, w.Component))
+}
+
+// queryRower is a *sql.DB or *sql.Tx.
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func hasWeightsTable(db queryRower) (bool, error) {
+	var n int
+	err := db.QueryRow(`
+	SELECT COUNT(*) FROM sqlite_master
+	WHERE type = 'table' AND name = 'embedding_weights'`).Scan(&n)
+	return n > 0, err
+}
+
+// LoadWeights loads the Weights stored by StoreWeights in the
+// embedding_weights and word_frequencies tables.
+// Returns nil if there are none.
+func LoadWeights(db *sql.DB) (*Weights, error) {
+	if ok, err := hasWeightsTable(db); err != nil || !ok {
+		return nil, err
+	}
+	var mode string
+	var component []byte
+
+	w := new(Weights)
+	err := db.QueryRow(`
+	SELECT mode, documents, words, component FROM embedding_weights`).Scan(
+		&mode, &w.Docs, &w.Words, &component)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if w.Mode = mode; w.Mode != TFIDF && w.Mode != SIF {
+		return nil, fmt.Errorf("invalid weighting mode %q", mode)
+	}
+	if component != nil {
+		if w.Component, err = vec32.FromBytes(component); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := db.Query(`SELECT word, freq, doc_freq FROM word_frequencies`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	w.Freq, w.DocFreq = make(map[string]int), make(map[string]int)
+	for rows.Next() {
+		var word string
+		var freq, docFreq int
+
+		if err := rows.Scan(&word, &freq, &docFreq); err != nil {
+			return nil, err
+		}
+		w.Freq[word], w.DocFreq[word] = freq, docFreq
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// StoreWeights replaces the Weights stored in the embedding_weights and
+// word_frequencies tables with w. If w is nil, the stored weights are removed,
+// if the tables exist.
+func StoreWeights(tx *sql.Tx, w *Weights) error {
+	ok, err := hasWeightsTable(tx)
+	if err != nil || !ok && w == nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM embedding_weights`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM word_frequencies`); err != nil {
+		return err
+	}
+	if w == nil {
+		return nil
+	}
+	var component []byte
+	if w.Component != nil {
+		component = vec32.Bytes(w.Component)
+	}
+	_, err = tx.Exec(`
+	INSERT INTO embedding_weights (mode, documents, words, component)
+	VALUES (?, ?, ?, ?)`, w.Mode, w.Docs, w.Words, component)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+	INSERT INTO word_frequencies (word, freq, doc_freq) VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for word, freq := range w.Freq {
+		if _, err := stmt.Exec(word, freq, w.DocFreq[word]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
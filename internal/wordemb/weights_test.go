@@ -0,0 +1,107 @@
+package wordemb
+
+import (
+	"database/sql"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestWeightedEmbedding(t *testing.T) {
+	g, err := loadTestGloVe(t, `data 1 0
+schools 0 1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mode := range []string{TFIDF, SIF} {
+		w, err := NewWeights(mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Count([]string{"Data"})
+		w.Count([]string{"city data"})
+		w.Count([]string{"schools data"})
+		g.SetWeights(w)
+
+		// "data" is in every text, so "schools" should dominate.
+		vec, err := g.Embed([]string{"data schools"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vec[1] <= vec[0] {
+			t.Errorf("%s: Embed(data schools) = %v, want schools weighted more", mode, vec)
+		}
+	}
+	g.SetWeights(nil)
+}
+
+func TestComputeComponent(t *testing.T) {
+	w, _ := NewWeights(SIF)
+	w.ComputeComponent([][]float32{{1, 0.1}, {1, -0.1}, {0.9, 0}})
+	if math.Abs(float64(w.Component[0])) < 0.99 {
+		t.Fatalf("Component = %v, want ±[1 0]", w.Component)
+	}
+	vec := []float32{1, 1}
+	w.RemoveComponent(vec)
+	if math.Abs(float64(vec[0])) > 1e-6 {
+		t.Errorf("RemoveComponent() = %v, want [0 1]", vec)
+	}
+}
+
+func TestStoreWeights(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if w, err := LoadWeights(db); w != nil || err != nil {
+		t.Fatalf("LoadWeights() = %v, %v without tables, want nil", w, err)
+	}
+	// As in sql/create_metadata_tables.sql.
+	if _, err := db.Exec(`
+	CREATE TABLE embedding_weights (
+		mode TEXT NOT NULL,
+		documents INTEGER NOT NULL,
+		words INTEGER NOT NULL,
+		component BLOB
+	);
+	CREATE TABLE word_frequencies (
+		word TEXT NOT NULL PRIMARY KEY,
+		freq INTEGER NOT NULL,
+		doc_freq INTEGER NOT NULL
+	);`); err != nil {
+		t.Fatal(err)
+	}
+
+	w, _ := NewWeights(SIF)
+	w.Count([]string{"city data", "data"})
+	w.Count([]string{"schools"})
+	w.Component = []float32{0.6, 0.8}
+	vec32.Normalize(w.Component)
+
+	for _, stored := range []*Weights{w, nil} {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := StoreWeights(tx, stored); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := LoadWeights(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, stored) {
+			t.Errorf("LoadWeights() = %+v, want %+v", got, stored)
+		}
+	}
+}
@@ -2,20 +2,19 @@
 package wordemb
 
 import (
+	"database/sql"
 	"errors"
-	"regexp"
-	"strings"
+	"fmt"
+	"io"
 
+	"github.com/DataIntelligenceCrew/OpenDataLink/internal/config"
 	"github.com/DataIntelligenceCrew/OpenDataLink/internal/vec32"
-	"github.com/ekzhu/go-fasttext"
 )
 
-// ErrNoEmb is returned by Vector when none of the input words have an
+// ErrNoEmb is returned by Embed when none of the input words have an
 // embedding.
 var ErrNoEmb = errors.New("no embeddings found for input words")
 
-var wordSepRe = regexp.MustCompile(`\W+`)
-
 // Lucene stop words list.
 var stopwords = map[string]bool{
 	"a":     true,
@@ -53,37 +52,304 @@ var stopwords = map[string]bool{
 	"with":  true,
 }
 
-// Vector creates an embedding vector for the given text by averaging the
-// fastText vectors of the words.
+// Embedder creates embedding vectors for text.
+type Embedder interface {
+	// Dim returns the dimension of the embedding vectors.
+	Dim() int
+	// Embed creates a normalized embedding vector for the given text.
+	// Returns a zero vector and ErrNoEmb if none of the words of the text
+	// have an embedding.
+	Embed(text []string) ([]float32, error)
+	// EmbedBatch creates an embedding vector for each of the given texts, as
+	// Embed. The vectors of the texts none of whose words have an embedding
+	// are nil.
+	EmbedBatch(texts [][]string) ([][]float32, error)
+}
+
+// Model is an Embedder that averages word vectors, and holds resources
+// released by Close.
+type Model interface {
+	Embedder
+	io.Closer
+	// SetWeights makes the model weight the words of a text by w, or take
+	// their unweighted mean if w is nil. It must not be called concurrently
+	// with embedding.
+	SetWeights(w *Weights)
+	// SetCacheSize sets the number of word vectors the model caches, other
+	// than preloaded ones. Models that hold their vectors in memory do not
+	// cache them.
+	SetCacheSize(n int)
+	// Preload looks up the words, compounds and phrases of the texts and
+	// keeps their vectors in memory, so that embedding text made of them
+	// does not look them up again.
+	Preload(texts [][]string) error
+	// CacheStats returns the statistics of the word vector cache.
+	CacheStats() CacheStats
+}
+
+// Open opens the configured embedding model: the GloVe word vectors at
+// config.GlovePath if it is set, and the fastText database at
+// config.FasttextPath otherwise. Words without a vector are embedded by the
+// fastText binary model at config.FasttextBinPath if it is set. The model is
+// weighted by the weights stored in db by process_metadata, if any, so that
+// text is embedded like the metadata; db may be nil for an unweighted model.
+func Open(db *sql.DB) (Model, error) {
+	var m Model
+	var a *averager
+	if path := config.GlovePath(); path != "" {
+		g, err := LoadGloVe(path)
+		if err != nil {
+			return nil, err
+		}
+		m, a = g, &g.averager
+	} else {
+		ft := NewFastText(config.FasttextPath())
+		m, a = ft, &ft.averager
+	}
+	if path := config.FasttextBinPath(); path != "" {
+		sw, err := OpenSubwordModel(path)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		a.subwords = sw
+		if sw.Dim() != m.Dim() {
+			m.Close()
+			return nil, fmt.Errorf("fastText binary model has dimension %d, but the embedding model has dimension %d",
+				sw.Dim(), m.Dim())
+		}
+	}
+	if db == nil {
+		return m, nil
+	}
+	w, err := LoadWeights(db)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	if w != nil && w.Component != nil && len(w.Component) != m.Dim() {
+		m.Close()
+		return nil, fmt.Errorf("embedding weights have dimension %d, but the embedding model has dimension %d",
+			len(w.Component), m.Dim())
+	}
+	m.SetWeights(w)
+	return m, nil
+}
+
+// words returns the words of a text other than stop words.
+func words(text []string) []string {
+	var words []string
+	for _, s := range text {
+		for _, word := range Tokenize(s) {
+			if !isStopword(word) {
+				words = append(words, word)
+			}
+		}
+	}
+	return words
+}
+
+// averager is an Embedder that averages the normalized vectors of the words
+// of a text, other than stop words, weighted by weights.
 //
-// Returns a zero vector and ErrNoEmb if none of the input words are found in
-// the FastText DB.
-func Vector(ft *fasttext.FastText, text []string) ([]float32, error) {
-	vec := make([]float32, fasttext.Dim)
-	foundEmb := false
-
-	for _, words := range text {
-		for _, word := range wordSepRe.Split(words, -1) {
-			if stopwords[strings.ToLower(word)] {
-				continue
+// Compounds and phrases of two words that have a vector, such as
+// "school-district" or "New_York", are embedded by their vector instead of
+// those of their words. Words without a vector are embedded by their subword
+// vectors if there is a subword model, and by the vectors of their character
+// n-grams otherwise.
+type averager struct {
+	dim int
+	// lookup returns the normalized vectors of the words that have one.
+	lookup   func(words []string) (map[string][]float32, error)
+	weights  *Weights
+	subwords *SubwordModel
+	// Cache of the vectors returned by lookup, nil if they are not cached.
+	cache *vectorCache
+}
+
+func (a *averager) Dim() int {
+	return a.dim
+}
+
+func (a *averager) SetWeights(w *Weights) {
+	a.weights = w
+}
+
+func (a *averager) SetCacheSize(n int) {
+	if a.cache != nil {
+		a.cache.setCapacity(n)
+	}
+}
+
+func (a *averager) Preload(texts [][]string) error {
+	if a.cache == nil {
+		return nil
+	}
+	_, keys := tokenizeTexts(texts)
+	embs, err := a.lookup(keys)
+	if err != nil {
+		return err
+	}
+	a.cache.preload(keys, embs)
+	return nil
+}
+
+func (a *averager) CacheStats() CacheStats {
+	if a.cache == nil {
+		return CacheStats{}
+	}
+	return a.cache.stats()
+}
+
+// cachedLookup returns the normalized vectors of the words that have one,
+// looking up the words that are not cached.
+func (a *averager) cachedLookup(words []string) (map[string][]float32, error) {
+	if a.cache == nil {
+		return a.lookup(words)
+	}
+	embs := make(map[string][]float32, len(words))
+	var missing []string
+
+	for _, word := range words {
+		vec, ok := a.cache.get(word)
+		if !ok {
+			missing = append(missing, word)
+		} else if vec != nil {
+			embs[word] = vec
+		}
+	}
+	if len(missing) == 0 {
+		return embs, nil
+	}
+	found, err := a.lookup(missing)
+	if err != nil {
+		return nil, err
+	}
+	for _, word := range missing {
+		vec := found[word]
+		a.cache.add(word, vec)
+		if vec != nil {
+			embs[word] = vec
+		}
+	}
+	return embs, nil
+}
+
+// tokenizeTexts returns the tokens of each string of the texts, and the
+// distinct words, compounds and phrases looked up to embed them.
+func tokenizeTexts(texts [][]string) ([][][]token, []string) {
+	textTokens := make([][][]token, len(texts))
+	seen := make(map[string]bool)
+	var keys []string
+
+	for i, text := range texts {
+		for _, s := range text {
+			tokens := tokenize(s)
+			textTokens[i] = append(textTokens[i], tokens)
+			for _, key := range candidates(tokens) {
+				if !seen[key] {
+					seen[key] = true
+					keys = append(keys, key)
+				}
+			}
+		}
+	}
+	return textTokens, keys
+}
+
+func (a *averager) Embed(text []string) ([]float32, error) {
+	vecs, err := a.EmbedBatch([][]string{text})
+	if err != nil {
+		return nil, err
+	}
+	if vecs[0] == nil {
+		return make([]float32, a.dim), ErrNoEmb
+	}
+	return vecs[0], nil
+}
+
+// EmbedBatch looks up each distinct word, compound and phrase of the texts
+// once.
+func (a *averager) EmbedBatch(texts [][]string) ([][]float32, error) {
+	textTokens, keys := tokenizeTexts(texts)
+	embs, err := a.cachedLookup(keys)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.lookupOOV(textTokens, embs); err != nil {
+		return nil, err
+	}
+	vecs := make([][]float32, len(texts))
+
+	for i := range texts {
+		var vec []float32
+		for _, tokens := range textTokens[i] {
+			for _, t := range terms(tokens, embs) {
+				if vec == nil {
+					vec = make([]float32, a.dim)
+				}
+				vec32.AddScaled(vec, embs[t.key], a.weight(t))
+			}
+		}
+		if vec != nil {
+			a.weights.RemoveComponent(vec)
+			if vec32.Norm(vec) > 0 {
+				vec32.Normalize(vec)
 			}
-			emb, err := ft.GetEmb(word)
-			if err != nil {
-				if err == fasttext.ErrNoEmbFound {
+		}
+		vecs[i] = vec
+	}
+	return vecs, nil
+}
+
+// weight returns the weight of a term, the mean weight of its words.
+func (a *averager) weight(t term) float32 {
+	if len(t.words) == 0 {
+		return a.weights.weight(t.key)
+	}
+	var sum float32
+	for _, word := range t.words {
+		sum += a.weights.weight(word)
+	}
+	return sum / float32(len(t.words))
+}
+
+// lookupOOV adds to embs the vectors of the words of the texts that have none.
+func (a *averager) lookupOOV(textTokens [][][]token, embs map[string][]float32) error {
+	seen := make(map[string]bool)
+	var oov []string
+	for _, text := range textTokens {
+		for _, tokens := range text {
+			for _, t := range tokens {
+				if _, ok := embs[t.word]; ok || seen[t.word] || isStopword(t.word) {
 					continue
 				}
-				return nil, err
+				seen[t.word] = true
+				oov = append(oov, t.word)
 			}
-			foundEmb = true
-			vec32.Normalize(emb)
-			vec32.Add(vec, emb)
 		}
 	}
-	vec32.Scale(vec, 1/float32(len(vec)))
-	vec32.Normalize(vec)
+	if len(oov) == 0 {
+		return nil
+	}
+	lookup := a.lookupNgrams
+	if a.subwords != nil {
+		lookup = a.subwords.lookup
+	}
+	oovEmbs, err := lookup(oov)
+	if err != nil {
+		return err
+	}
+	for word, emb := range oovEmbs {
+		embs[word] = emb
+	}
+	return nil
+}
 
-	if !foundEmb {
-		return vec, ErrNoEmb
+// closeSubwords closes the subword model, if any.
+func (a *averager) closeSubwords() error {
+	if a.subwords == nil {
+		return nil
 	}
-	return vec, nil
+	return a.subwords.Close()
 }
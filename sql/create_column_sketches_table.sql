@@ -1,3 +1,16 @@
+-- Versions of the tables the indexes are built from, maintained by triggers.
+-- With the number of rows and the largest rowid of a table, they tell whether
+-- the table changed since an index was built without reading it: inserted rows
+-- change these, and updated and deleted rows increment the version.
+CREATE TABLE IF NOT EXISTS table_versions (
+    -- Name of the table.
+    name TEXT NOT NULL PRIMARY KEY,
+    -- Random ID of the table, set when it is created.
+    generation TEXT NOT NULL,
+    -- Number of rows updated or deleted since the table was created.
+    version INTEGER NOT NULL
+);
+
 CREATE TABLE column_sketches (
     -- dataset_id followed by a dash and the column number.
     column_id TEXT NOT NULL PRIMARY KEY,
@@ -13,3 +26,39 @@ CREATE TABLE column_sketches (
     sample TEXT NOT NULL
 );
 CREATE INDEX column_sketches_dataset_idx ON column_sketches(dataset_id);
+INSERT OR REPLACE INTO table_versions (name, generation, version)
+VALUES ('column_sketches', hex(randomblob(16)), 0);
+CREATE TRIGGER column_sketches_update AFTER UPDATE ON column_sketches
+BEGIN
+    UPDATE table_versions SET version = version + 1 WHERE name = 'column_sketches';
+END;
+CREATE TRIGGER column_sketches_delete AFTER DELETE ON column_sketches
+BEGIN
+    UPDATE table_versions SET version = version + 1 WHERE name = 'column_sketches';
+END;
+
+CREATE TABLE column_pair_sketches (
+    -- dataset_id followed by a dash and the column numbers separated by a
+    -- dash, in increasing order.
+    pair_id TEXT NOT NULL PRIMARY KEY,
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- The column IDs of the pair.
+    column_id1 TEXT NOT NULL,
+    column_id2 TEXT NOT NULL,
+    -- An approximate distinct count of the value pairs.
+    distinct_count INT NOT NULL,
+    -- The minhash signature of the value pairs.
+    minhash BLOB NOT NULL
+);
+CREATE INDEX column_pair_sketches_dataset_idx ON column_pair_sketches(dataset_id);
+INSERT OR REPLACE INTO table_versions (name, generation, version)
+VALUES ('column_pair_sketches', hex(randomblob(16)), 0);
+CREATE TRIGGER column_pair_sketches_update AFTER UPDATE ON column_pair_sketches
+BEGIN
+    UPDATE table_versions SET version = version + 1 WHERE name = 'column_pair_sketches';
+END;
+CREATE TRIGGER column_pair_sketches_delete AFTER DELETE ON column_pair_sketches
+BEGIN
+    UPDATE table_versions SET version = version + 1 WHERE name = 'column_pair_sketches';
+END;
@@ -19,9 +19,90 @@ CREATE TABLE metadata (
     permalink TEXT NOT NULL
 );
 
+-- Versions of the tables the indexes are built from, maintained by triggers.
+-- With the number of rows and the largest rowid of a table, they tell whether
+-- the table changed since an index was built without reading it: inserted rows
+-- change these, and updated and deleted rows increment the version.
+CREATE TABLE IF NOT EXISTS table_versions (
+    -- Name of the table.
+    name TEXT NOT NULL PRIMARY KEY,
+    -- Random ID of the table, set when it is created.
+    generation TEXT NOT NULL,
+    -- Number of rows updated or deleted since the table was created.
+    version INTEGER NOT NULL
+);
+
 CREATE TABLE metadata_vectors (
     -- The Socrata dataset four-by-four.
     dataset_id TEXT NOT NULL PRIMARY KEY,
     -- Embedding vector.
     emb BLOB NOT NULL
 );
+INSERT OR REPLACE INTO table_versions (name, generation, version)
+VALUES ('metadata_vectors', hex(randomblob(16)), 0);
+CREATE TRIGGER metadata_vectors_update AFTER UPDATE ON metadata_vectors
+BEGIN
+    UPDATE table_versions SET version = version + 1 WHERE name = 'metadata_vectors';
+END;
+CREATE TRIGGER metadata_vectors_delete AFTER DELETE ON metadata_vectors
+BEGIN
+    UPDATE table_versions SET version = version + 1 WHERE name = 'metadata_vectors';
+END;
+
+-- Embedding vectors of the metadata fields, whose weighted combination is the
+-- vector in metadata_vectors. Fields none of whose words have an embedding
+-- have no vector.
+CREATE TABLE metadata_field_vectors (
+    -- The Socrata dataset four-by-four.
+    dataset_id TEXT NOT NULL,
+    -- The field: name, description, attribution, categories or tags.
+    field TEXT NOT NULL,
+    -- Embedding vector.
+    emb BLOB NOT NULL,
+    PRIMARY KEY (dataset_id, field)
+);
+INSERT OR REPLACE INTO table_versions (name, generation, version)
+VALUES ('metadata_field_vectors', hex(randomblob(16)), 0);
+CREATE TRIGGER metadata_field_vectors_update AFTER UPDATE ON metadata_field_vectors
+BEGIN
+    UPDATE table_versions SET version = version + 1 WHERE name = 'metadata_field_vectors';
+END;
+CREATE TRIGGER metadata_field_vectors_delete AFTER DELETE ON metadata_field_vectors
+BEGIN
+    UPDATE table_versions SET version = version + 1 WHERE name = 'metadata_field_vectors';
+END;
+
+-- Full-text index over the metadata for keyword search.
+-- Requires SQLite with FTS5 (build Go programs with -tags sqlite_fts5).
+CREATE VIRTUAL TABLE metadata_fts USING fts5(
+    dataset_id UNINDEXED,
+    name,
+    description,
+    attribution,
+    categories,
+    tags
+);
+
+-- Word statistics of the metadata used to weight the words of embedded text,
+-- written by process_metadata with -weighting tfidf or -weighting sif.
+-- The table has at most one row.
+CREATE TABLE embedding_weights (
+    -- Weighting mode, "tfidf" or "sif".
+    mode TEXT NOT NULL,
+    -- Number of datasets.
+    documents INTEGER NOT NULL,
+    -- Number of word occurrences in the metadata.
+    words INTEGER NOT NULL,
+    -- First principal component of the metadata vectors, removed from SIF
+    -- embeddings, as a big-endian float32 vector. NULL for TF-IDF weights.
+    component BLOB
+);
+
+CREATE TABLE word_frequencies (
+    -- Lowercase word.
+    word TEXT NOT NULL PRIMARY KEY,
+    -- Number of occurrences of the word in the metadata.
+    freq INTEGER NOT NULL,
+    -- Number of datasets whose metadata contains the word.
+    doc_freq INTEGER NOT NULL
+);
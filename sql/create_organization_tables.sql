@@ -0,0 +1,16 @@
+CREATE TABLE organizations (
+    -- Opaque organization ID.
+    organization_id TEXT NOT NULL PRIMARY KEY,
+    -- Hash of the organization name, configuration and dataset IDs.
+    -- Used to reuse organizations of the same datasets.
+    organization_key TEXT NOT NULL,
+    -- The organization name (name of the root node).
+    name TEXT NOT NULL,
+    -- Number of datasets in the organization.
+    dataset_count INT NOT NULL,
+    -- Creation timestamp in RFC 3339 format.
+    created_at TEXT NOT NULL,
+    -- The organization graph encoded as JSON.
+    graph BLOB NOT NULL
+);
+CREATE INDEX organizations_key_idx ON organizations(organization_key);